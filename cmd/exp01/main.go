@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/csv"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
@@ -14,7 +15,20 @@ import (
 	"github.com/DennisPing/Performance-Analysis-TCP-Variants/pkg"
 )
 
+// Keep each trial's trace as a gzipped artifact instead of deleting it
+var keep_traces = flag.Bool("keep", false, "keep each trial's trace gzipped in results/exp01/traces")
+
+// Drop the first seconds of every flow so its start-up transient does not skew the averages
+var warmup = flag.Float64("warmup", 0, "ignore the first `seconds` of every flow")
+
+// Measure latency over the N2 -> N3 hop or end-to-end from each flow's source to its sink
+var latency_mode = flag.String("latency", "hop", "latency to measure: 'hop' for N2 -> N3 or 'e2e' for source to sink")
+
 func main() {
+	flag.Parse()
+	if *latency_mode != "hop" && *latency_mode != "e2e" {
+		panic("-latency must be hop or e2e")
+	}
 
 	agents := []string{"Agent/TCP", "Agent/TCP/Reno", "Agent/TCP/Newreno", "Agent/TCP/Vegas"}
 
@@ -28,6 +42,9 @@ func main() {
 	if _, err := os.Stat(basedir + "/results/exp01"); os.IsNotExist(err) {
 		os.Mkdir(basedir+"/results/exp01", 0777)
 	}
+	if _, err := os.Stat(basedir + "/results/exp01/traces"); *keep_traces && os.IsNotExist(err) {
+		os.Mkdir(basedir+"/results/exp01/traces", 0777)
+	}
 
 	wg := new(sync.WaitGroup)
 	wg.Add(len(agents))
@@ -57,9 +74,30 @@ func Experiment01(wg *sync.WaitGroup, agent string) {
 		panic(err)
 	}
 	defer file.Close()
-	file.WriteString("cbr_rate,avg_throughput,std_throughput,avg_latency,std_latency,avg_drops,std_drops\n")
+	file.WriteString("cbr_rate,avg_throughput,std_throughput,avg_goodput,std_goodput,avg_retransmissions,std_retransmissions," +
+		"avg_retransmission_ratio,std_retransmission_ratio,avg_latency,std_latency,avg_queueing_delay," +
+		"std_queueing_delay,avg_drops,std_drops,avg_utilization,std_utilization,avg_link_throughput,std_link_throughput," +
+		"avg_loss_rate,std_loss_rate,avg_loss_burst,std_loss_burst,avg_gilbert_p,std_gilbert_p,avg_gilbert_r," +
+		"std_gilbert_r\n")
 	file.Close()
 
+	// Find the TCP flow and the bottleneck it shares with CBR in the first trial's trace, which is then
+	// measured like any other. The bottleneck and its bandwidth come from the script's topology
+	topology, err := pkg.ReadNs2Topology("../ns2/simulation01.tcl")
+	if err != nil {
+		panic(err)
+	}
+	trace_file := Simulation01(agent, 0.5, 0.0, 1)
+	flows, bottleneck, err := pkg.DiscoverTraceFile(trace_file, topology)
+	if err != nil {
+		panic(err)
+	}
+	tcp_flows, _ := pkg.SplitAckedFlows(flows)
+	if len(tcp_flows) != 1 {
+		panic(fmt.Sprintf("expected 1 TCP flow in simulation01.tcl, found %d", len(tcp_flows)))
+	}
+	tcp_flow := tcp_flows[0]
+
 	var results [][]float64
 
 	// The main simulation loop for cbr_rate of from 1 to 9 Mbps
@@ -67,30 +105,86 @@ func Experiment01(wg *sync.WaitGroup, agent string) {
 		start := time.Now()
 		fmt.Printf("Starting %s with rate %d\n", suffix, rate)
 		cumul_throughputs := make([]float64, 0)
+		cumul_goodputs := make([]float64, 0)
+		cumul_retransmissions := make([]float64, 0)
+		cumul_retransmission_ratios := make([]float64, 0)
 		cumul_latencies := make([]float64, 0)
+		cumul_queueing_delays := make([]float64, 0)
 		cumul_drops := make([]float64, 0)
+		cumul_utilizations := make([]float64, 0)
+		cumul_link_throughputs := make([]float64, 0)
+		cumul_loss_rates := make([]float64, 0)
+		cumul_loss_bursts := make([]float64, 0)
+		cumul_gilbert_ps := make([]float64, 0)
+		cumul_gilbert_rs := make([]float64, 0)
 
 		// Simulation variables
-		fid := 1
-		from_node := 1 // ns2 counts from 0, so this is N2 -> N3
-		to_node := 2
+		from_node := bottleneck.From
+		to_node := bottleneck.To
+		bandwidth := topology.Bandwidths[bottleneck]
 		cbr_start := 0.0
 
 		for tcp_start := 0.5; tcp_start <= 5.5; tcp_start += 0.1 {
-			traces := Simulation01(agent, tcp_start, cbr_start, float64(rate))
-			// Prepare the trace data
-			traces = pkg.FilterByType(traces, "tcp")
-			traces = pkg.FilterByFid(traces, fid)
+			if trace_file == "" { // The first trial already ran to discover the flows
+				trace_file = Simulation01(agent, tcp_start, cbr_start, float64(rate))
+			}
 
-			// Calculate throughput, latency, and dropped packets
+			// Calculate throughput, latency, and dropped packets in a single pass
 			window_size := 0.2
-			_, _, throughput := pkg.CalculateThroughput(traces, from_node, to_node, tcp_start, window_size)
-			_, _, latency := pkg.CalculateLatency(traces, from_node, to_node, tcp_start)
-			drops := pkg.CountDrops(traces)
+			span := pkg.WarmupSpan(tcp_start, *warmup)
+			throughput_meter := pkg.NewThroughputMeter(from_node, to_node, span, window_size)
+			latency_meter := NewLatencyMeter(from_node, to_node, span)
+			queueing_meter := pkg.NewDelayBreakdownMeter(from_node, to_node, bandwidth, span)
+			drop_counter := pkg.NewDropCounter(span)
+			goodput_meter := pkg.NewGoodputMeter(span)
+			loss_meter := pkg.NewLossMeter(span)
+
+			// The link is shared with CBR, so it sees every trace to tell how full the link is
+			utilization_meter := pkg.NewUtilizationMeter(from_node, to_node, bandwidth, span)
+
+			is_tcp := pkg.TypeFilter(tcp_flow.DataType())
+			is_flow := pkg.FidFilter(tcp_flow.Fid)
+			tag := "_rate" + strconv.Itoa(rate) + "_tcp" + strconv.FormatFloat(tcp_start, 'f', 1, 64)
+			ScanTrial(trace_file, tag, func(trace *pkg.Trace) {
+				utilization_meter.Add(trace)
+				if !is_tcp(trace) || !is_flow(trace) {
+					return
+				}
+				throughput_meter.Add(trace)
+				latency_meter.Add(trace)
+				queueing_meter.Add(trace)
+				drop_counter.Add(trace)
+				goodput_meter.Add(trace)
+				loss_meter.Add(trace)
+			})
+			trace_file = ""
+
+			_, _, throughput := throughput_meter.Result()
+			_, _, latency := latency_meter.Result()
+			_, _, queueing_delay := queueing_meter.Result()
+			drops := drop_counter.Result()
+			goodput, retransmissions, retransmission_ratio := goodput_meter.Result()
+			utilization := utilization_meter.Utilization()
+			link_throughput := utilization_meter.Throughput()
+			loss := loss_meter.Result()
 
 			cumul_throughputs = append(cumul_throughputs, throughput)
+			cumul_goodputs = append(cumul_goodputs, goodput)
+			cumul_retransmissions = append(cumul_retransmissions, float64(retransmissions))
+			cumul_retransmission_ratios = append(cumul_retransmission_ratios, retransmission_ratio)
 			cumul_latencies = append(cumul_latencies, latency)
+			cumul_queueing_delays = append(cumul_queueing_delays, queueing_delay)
 			cumul_drops = append(cumul_drops, float64(drops))
+			cumul_utilizations = append(cumul_utilizations, utilization)
+			cumul_link_throughputs = append(cumul_link_throughputs, link_throughput)
+			cumul_loss_rates = append(cumul_loss_rates, loss.Rate)
+			cumul_gilbert_ps = append(cumul_gilbert_ps, loss.Model.P)
+			// Bursts only exist in trials that lost something. If no trial at this
+			// rate did, the burst and Gilbert R columns are NaN, as the README says
+			if loss.Lost > 0 {
+				cumul_loss_bursts = append(cumul_loss_bursts, loss.MeanBurst())
+				cumul_gilbert_rs = append(cumul_gilbert_rs, loss.Model.R)
+			}
 		}
 
 		avg_throughput := pkg.Mean(cumul_throughputs)
@@ -98,10 +192,33 @@ func Experiment01(wg *sync.WaitGroup, agent string) {
 		avg_drops := pkg.Mean(cumul_drops)
 		std_throughput := pkg.StdDev(cumul_throughputs)
 		std_latency := pkg.StdDev(cumul_latencies)
+		avg_queueing_delay := pkg.Mean(cumul_queueing_delays)
+		std_queueing_delay := pkg.StdDev(cumul_queueing_delays)
 		std_drops := pkg.StdDev(cumul_drops)
+		avg_utilization := pkg.Mean(cumul_utilizations)
+		std_utilization := pkg.StdDev(cumul_utilizations)
+		avg_link_throughput := pkg.Mean(cumul_link_throughputs)
+		std_link_throughput := pkg.StdDev(cumul_link_throughputs)
+		avg_loss_rate := pkg.Mean(cumul_loss_rates)
+		std_loss_rate := pkg.StdDev(cumul_loss_rates)
+		avg_loss_burst := pkg.Mean(cumul_loss_bursts)
+		std_loss_burst := pkg.StdDev(cumul_loss_bursts)
+		avg_gilbert_p := pkg.Mean(cumul_gilbert_ps)
+		std_gilbert_p := pkg.StdDev(cumul_gilbert_ps)
+		avg_gilbert_r := pkg.Mean(cumul_gilbert_rs)
+		std_gilbert_r := pkg.StdDev(cumul_gilbert_rs)
+		avg_goodput := pkg.Mean(cumul_goodputs)
+		std_goodput := pkg.StdDev(cumul_goodputs)
+		avg_retransmissions := pkg.Mean(cumul_retransmissions)
+		std_retransmissions := pkg.StdDev(cumul_retransmissions)
+		avg_retransmission_ratio := pkg.Mean(cumul_retransmission_ratios)
+		std_retransmission_ratio := pkg.StdDev(cumul_retransmission_ratios)
 
-		results = append(results, []float64{float64(rate), avg_throughput, std_throughput, avg_latency,
-			std_latency, avg_drops, std_drops})
+		results = append(results, []float64{float64(rate), avg_throughput, std_throughput, avg_goodput, std_goodput,
+			avg_retransmissions, std_retransmissions, avg_retransmission_ratio, std_retransmission_ratio, avg_latency,
+			std_latency, avg_queueing_delay, std_queueing_delay, avg_drops, std_drops, avg_utilization, std_utilization,
+			avg_link_throughput, std_link_throughput, avg_loss_rate, std_loss_rate, avg_loss_burst, std_loss_burst,
+			avg_gilbert_p, std_gilbert_p, avg_gilbert_r, std_gilbert_r})
 
 		end := time.Since(start).Round(time.Second)
 		fmt.Printf("Finished %s with rate %d in %s\n", suffix, rate, end)
@@ -126,8 +243,8 @@ func Experiment01(wg *sync.WaitGroup, agent string) {
 	}
 }
 
-// Run Simulation 1 using ns2 and return a slice of traces
-func Simulation01(agent string, tcp_start float64, cbr_start float64, cbr_rate float64) []*pkg.Trace {
+// Run Simulation 1 using ns2 and return the name of its trace file
+func Simulation01(agent string, tcp_start float64, cbr_start float64, cbr_rate float64) string {
 
 	split := strings.Split(agent, "/")
 	suffix := split[len(split)-1]
@@ -142,11 +259,35 @@ func Simulation01(agent string, tcp_start float64, cbr_start float64, cbr_rate f
 	if err != nil {
 		panic(err)
 	}
+	return filename
+}
 
-	traces, err := pkg.ParseTraceFile(filename)
+// Stream a trial's trace through 'visit' and delete it. With -keep the trace
+// is first archived gzipped, named after the trace file plus 'tag'
+func ScanTrial(filename string, tag string, visit func(*pkg.Trace)) {
+	// Skip malformed lines, but warn that the trial may not be trustworthy
+	skipped, err := pkg.ScanTraceFileLenient(filename, visit)
 	if err != nil {
 		panic(err)
 	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d malformed lines in %s\n", skipped, filename)
+	}
+	if *keep_traces {
+		pwd, _ := os.Getwd()
+		archive := filepath.Dir(pwd) + "/results/exp01/traces/" + strings.TrimSuffix(filename, ".tr") + tag + ".tr.gz"
+		err = pkg.CompressFile(filename, archive)
+		if err != nil {
+			panic(err)
+		}
+	}
 	os.Remove(filename)
-	return traces
+}
+
+// Create the latency meter selected by the -latency flag
+func NewLatencyMeter(from_node int, to_node int, span pkg.TimeSpan) pkg.SeriesMeter {
+	if *latency_mode == "e2e" {
+		return pkg.NewDelayMeter(span)
+	}
+	return pkg.NewLatencyMeter(from_node, to_node, span)
 }
@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/csv"
+	"flag"
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,7 +16,20 @@ import (
 	"github.com/DennisPing/Performance-Analysis-TCP-Variants/pkg"
 )
 
+// Keep each trial's trace as a gzipped artifact instead of deleting it
+var keep_traces = flag.Bool("keep", false, "keep each trial's trace gzipped in results/exp02/traces")
+
+// Drop the first seconds of every flow so its start-up transient does not skew the averages
+var warmup = flag.Float64("warmup", 0, "ignore the first `seconds` of every flow")
+
+// Measure latency over the N2 -> N3 hop or end-to-end from each flow's source to its sink
+var latency_mode = flag.String("latency", "hop", "latency to measure: 'hop' for N2 -> N3 or 'e2e' for source to sink")
+
 func main() {
+	flag.Parse()
+	if *latency_mode != "hop" && *latency_mode != "e2e" {
+		panic("-latency must be hop or e2e")
+	}
 
 	agents := []string{"Agent/TCP", "Agent/TCP/Reno", "Agent/TCP/Newreno", "Agent/TCP/Vegas"}
 
@@ -34,6 +49,9 @@ func main() {
 	if _, err := os.Stat(basedir + "/results/exp02"); os.IsNotExist(err) {
 		os.Mkdir(basedir+"/results/exp02", 0777)
 	}
+	if _, err := os.Stat(basedir + "/results/exp02/traces"); *keep_traces && os.IsNotExist(err) {
+		os.Mkdir(basedir+"/results/exp02/traces", 0777)
+	}
 
 	wg := new(sync.WaitGroup)
 	wg.Add(len(combos))
@@ -72,9 +90,38 @@ func Experiment02(wg *sync.WaitGroup, combo []string) {
 		panic(err)
 	}
 	defer file.Close()
-	header := "cbr_rate,avg_throughput1,std_throughput1,avg_latency1,std_latency1,avg_drops1,std_drops1," +
-		"avg_throughput2,std_throughput2,avg_latency2,std_latency2,avg_drops2,std_drops2\n"
-	file.WriteString(header)
+
+	// Find both TCP flows and the bottleneck they share in the first trial's trace, which is then
+	// measured like any other. The bottleneck and its bandwidth come from the script's topology
+	topology, err := pkg.ReadNs2Topology("../ns2/simulation02.tcl")
+	if err != nil {
+		panic(err)
+	}
+	trace_file := Simulation02(agent1, agent2, 0.0, 1)
+	flows, bottleneck, err := pkg.DiscoverTraceFile(trace_file, topology)
+	if err != nil {
+		panic(err)
+	}
+	tcp_flows, _ := pkg.SplitAckedFlows(flows)
+	if len(tcp_flows) != 2 {
+		panic(fmt.Sprintf("expected 2 TCP flows in simulation02.tcl, found %d", len(tcp_flows)))
+	}
+	tcp_flow1 := tcp_flows[0] // Flows are sorted by fid, so the first one runs agent1
+	tcp_flow2 := tcp_flows[1]
+	fids := []int{tcp_flow1.Fid, tcp_flow2.Fid}
+
+	header := "cbr_rate,avg_throughput1,std_throughput1,avg_goodput1,std_goodput1,avg_retransmissions1," +
+		"std_retransmissions1,avg_retransmission_ratio1,std_retransmission_ratio1,avg_latency1,std_latency1," +
+		"avg_queueing_delay1,std_queueing_delay1,avg_drops1,std_drops1," +
+		"avg_throughput2,std_throughput2,avg_goodput2,std_goodput2,avg_retransmissions2,std_retransmissions2," +
+		"avg_retransmission_ratio2,std_retransmission_ratio2,avg_latency2,std_latency2,avg_queueing_delay2," +
+		"std_queueing_delay2,avg_drops2,std_drops2,avg_jain_index,std_jain_index,avg_max_min_ratio," +
+		"std_max_min_ratio,avg_windowed_jain_index,std_windowed_jain_index"
+	// One pair of share columns per flow, in the order of 'fids'
+	for i := range fids {
+		header += ",avg_share" + strconv.Itoa(i+1) + ",std_share" + strconv.Itoa(i+1)
+	}
+	file.WriteString(header + "\n")
 	file.Close()
 
 	var results [][]float64
@@ -84,43 +131,118 @@ func Experiment02(wg *sync.WaitGroup, combo []string) {
 		start := time.Now()
 		fmt.Printf("Starting %s/%s with rate %d\n", suffix1, suffix2, rate)
 		cumul_throughputs1 := make([]float64, 0)
+		cumul_goodputs1 := make([]float64, 0)
+		cumul_retransmissions1 := make([]float64, 0)
+		cumul_retransmission_ratios1 := make([]float64, 0)
 		cumul_latencies1 := make([]float64, 0)
+		cumul_queueing_delays1 := make([]float64, 0)
 		cumul_drops1 := make([]float64, 0)
 
 		cumul_throughputs2 := make([]float64, 0)
+		cumul_goodputs2 := make([]float64, 0)
+		cumul_retransmissions2 := make([]float64, 0)
+		cumul_retransmission_ratios2 := make([]float64, 0)
 		cumul_latencies2 := make([]float64, 0)
+		cumul_queueing_delays2 := make([]float64, 0)
 		cumul_drops2 := make([]float64, 0)
 
 		// Simulation variables
-		from_node := 1 // ns2 counts from 0, so this is N2 -> N3
-		to_node := 2
-
+		from_node := bottleneck.From
+		to_node := bottleneck.To
+		bandwidth := topology.Bandwidths[bottleneck]
+		tcp1_start := 4.0 // simulation02.tcl always starts TCP1 at 4 seconds
+
+		cumul_fairness := make([]float64, 0)
+		cumul_max_min_ratios := make([]float64, 0)
+		cumul_windowed_fairness := make([]float64, 0)
+		cumul_shares := make([][]float64, len(fids))
 		for tcp2_start := 0.0; tcp2_start <= 8.0; tcp2_start += 0.16 {
-			traces := Simulation02(agent1, agent2, tcp2_start, float64(rate))
-
-			// Prepare the trace data
-			traces = pkg.FilterByType(traces, "tcp")
-			traces1 := pkg.FilterByFid(traces, 1)
-			traces2 := pkg.FilterByFid(traces, 2)
+			if trace_file == "" { // The first trial already ran to discover the flows
+				trace_file = Simulation02(agent1, agent2, tcp2_start, float64(rate))
+			}
 
-			// Calculate throughput, latency, and dropped packets
+			// Calculate throughput, latency, and dropped packets in a single pass
 			window_size := 0.2
-			_, _, throughput1 := pkg.CalculateThroughput(traces1, from_node, to_node, tcp2_start, window_size)
-			_, _, latency1 := pkg.CalculateLatency(traces1, from_node, to_node, tcp2_start)
-			drops1 := pkg.CountDrops(traces1)
-
-			_, _, throughput2 := pkg.CalculateThroughput(traces2, from_node, to_node, tcp2_start, window_size)
-			_, _, latency2 := pkg.CalculateLatency(traces2, from_node, to_node, tcp2_start)
-			drops2 := pkg.CountDrops(traces2)
+			span1 := pkg.WarmupSpan(tcp1_start, *warmup)
+			throughput_meter1 := pkg.NewThroughputMeter(from_node, to_node, span1, window_size)
+			latency_meter1 := NewLatencyMeter(from_node, to_node, span1)
+			queueing_meter1 := pkg.NewDelayBreakdownMeter(from_node, to_node, bandwidth, span1)
+			drop_counter1 := pkg.NewDropCounter(span1)
+			goodput_meter1 := pkg.NewGoodputMeter(span1)
+
+			span2 := pkg.WarmupSpan(tcp2_start, *warmup)
+			throughput_meter2 := pkg.NewThroughputMeter(from_node, to_node, span2, window_size)
+			latency_meter2 := NewLatencyMeter(from_node, to_node, span2)
+			queueing_meter2 := pkg.NewDelayBreakdownMeter(from_node, to_node, bandwidth, span2)
+			drop_counter2 := pkg.NewDropCounter(span2)
+			goodput_meter2 := pkg.NewGoodputMeter(span2)
+
+			// Fairness over time only means something once every flow is running
+			fairness_span := pkg.WarmupSpan(math.Max(tcp1_start, tcp2_start), *warmup)
+			fairness_meter := pkg.NewFairnessMeter(from_node, to_node, fairness_span, window_size, fids...)
+
+			is_flow1 := pkg.And(pkg.TypeFilter(tcp_flow1.DataType()), pkg.FidFilter(tcp_flow1.Fid))
+			is_flow2 := pkg.And(pkg.TypeFilter(tcp_flow2.DataType()), pkg.FidFilter(tcp_flow2.Fid))
+			tag := "_rate" + strconv.Itoa(rate) + "_tcp" + strconv.FormatFloat(tcp2_start, 'f', 2, 64)
+			ScanTrial(trace_file, tag, func(trace *pkg.Trace) {
+				if !is_flow1(trace) && !is_flow2(trace) {
+					return
+				}
+				fairness_meter.Add(trace)
+				if is_flow1(trace) {
+					throughput_meter1.Add(trace)
+					latency_meter1.Add(trace)
+					queueing_meter1.Add(trace)
+					drop_counter1.Add(trace)
+					goodput_meter1.Add(trace)
+				} else if is_flow2(trace) {
+					throughput_meter2.Add(trace)
+					latency_meter2.Add(trace)
+					queueing_meter2.Add(trace)
+					drop_counter2.Add(trace)
+					goodput_meter2.Add(trace)
+				}
+			})
+			trace_file = ""
+
+			_, _, throughput1 := throughput_meter1.Result()
+			_, _, latency1 := latency_meter1.Result()
+			_, _, queueing_delay1 := queueing_meter1.Result()
+			drops1 := drop_counter1.Result()
+			goodput1, retransmissions1, retransmission_ratio1 := goodput_meter1.Result()
+
+			_, _, throughput2 := throughput_meter2.Result()
+			_, _, latency2 := latency_meter2.Result()
+			_, _, queueing_delay2 := queueing_meter2.Result()
+			drops2 := drop_counter2.Result()
+			goodput2, retransmissions2, retransmission_ratio2 := goodput_meter2.Result()
+
+			throughputs := []float64{throughput1, throughput2}
+			_, _, windowed_fairness := fairness_meter.Result()
 
 			// Add the results to the cumulative results
 			cumul_throughputs1 = append(cumul_throughputs1, throughput1)
+			cumul_goodputs1 = append(cumul_goodputs1, goodput1)
+			cumul_retransmissions1 = append(cumul_retransmissions1, float64(retransmissions1))
+			cumul_retransmission_ratios1 = append(cumul_retransmission_ratios1, retransmission_ratio1)
 			cumul_latencies1 = append(cumul_latencies1, latency1)
+			cumul_queueing_delays1 = append(cumul_queueing_delays1, queueing_delay1)
 			cumul_drops1 = append(cumul_drops1, float64(drops1))
 
 			cumul_throughputs2 = append(cumul_throughputs2, throughput2)
+			cumul_goodputs2 = append(cumul_goodputs2, goodput2)
+			cumul_retransmissions2 = append(cumul_retransmissions2, float64(retransmissions2))
+			cumul_retransmission_ratios2 = append(cumul_retransmission_ratios2, retransmission_ratio2)
 			cumul_latencies2 = append(cumul_latencies2, latency2)
+			cumul_queueing_delays2 = append(cumul_queueing_delays2, queueing_delay2)
 			cumul_drops2 = append(cumul_drops2, float64(drops2))
+
+			cumul_fairness = append(cumul_fairness, pkg.JainIndex(throughputs))
+			cumul_max_min_ratios = append(cumul_max_min_ratios, pkg.MaxMinRatio(throughputs))
+			cumul_windowed_fairness = append(cumul_windowed_fairness, windowed_fairness)
+			for i, share := range pkg.Shares(throughputs) {
+				cumul_shares[i] = append(cumul_shares[i], share)
+			}
 		}
 
 		avg_throughput1 := pkg.Mean(cumul_throughputs1)
@@ -128,18 +250,50 @@ func Experiment02(wg *sync.WaitGroup, combo []string) {
 		avg_drops1 := pkg.Mean(cumul_drops1)
 		std_throughput1 := pkg.StdDev(cumul_throughputs1)
 		std_latency1 := pkg.StdDev(cumul_latencies1)
+		avg_queueing_delay1 := pkg.Mean(cumul_queueing_delays1)
+		std_queueing_delay1 := pkg.StdDev(cumul_queueing_delays1)
 		std_drops1 := pkg.StdDev(cumul_drops1)
+		avg_goodput1 := pkg.Mean(cumul_goodputs1)
+		std_goodput1 := pkg.StdDev(cumul_goodputs1)
+		avg_retransmissions1 := pkg.Mean(cumul_retransmissions1)
+		std_retransmissions1 := pkg.StdDev(cumul_retransmissions1)
+		avg_retransmission_ratio1 := pkg.Mean(cumul_retransmission_ratios1)
+		std_retransmission_ratio1 := pkg.StdDev(cumul_retransmission_ratios1)
 
 		avg_throughput2 := pkg.Mean(cumul_throughputs2)
 		avg_latency2 := pkg.Mean(cumul_latencies2)
 		avg_drops2 := pkg.Mean(cumul_drops2)
 		std_throughput2 := pkg.StdDev(cumul_throughputs2)
 		std_latency2 := pkg.StdDev(cumul_latencies2)
+		avg_queueing_delay2 := pkg.Mean(cumul_queueing_delays2)
+		std_queueing_delay2 := pkg.StdDev(cumul_queueing_delays2)
 		std_drops2 := pkg.StdDev(cumul_drops2)
-
-		results = append(results,
-			[]float64{float64(rate), avg_throughput1, std_throughput1, avg_latency1, std_latency1, avg_drops1, std_drops1,
-				avg_throughput2, std_throughput2, avg_latency2, std_latency2, avg_drops2, std_drops2})
+		avg_goodput2 := pkg.Mean(cumul_goodputs2)
+		std_goodput2 := pkg.StdDev(cumul_goodputs2)
+		avg_retransmissions2 := pkg.Mean(cumul_retransmissions2)
+		std_retransmissions2 := pkg.StdDev(cumul_retransmissions2)
+		avg_retransmission_ratio2 := pkg.Mean(cumul_retransmission_ratios2)
+		std_retransmission_ratio2 := pkg.StdDev(cumul_retransmission_ratios2)
+
+		avg_fairness := pkg.Mean(cumul_fairness)
+		std_fairness := pkg.StdDev(cumul_fairness)
+		avg_max_min_ratio := pkg.Mean(cumul_max_min_ratios)
+		std_max_min_ratio := pkg.StdDev(cumul_max_min_ratios)
+		avg_windowed_fairness := pkg.Mean(cumul_windowed_fairness)
+		std_windowed_fairness := pkg.StdDev(cumul_windowed_fairness)
+
+		result := []float64{float64(rate), avg_throughput1, std_throughput1, avg_goodput1, std_goodput1,
+			avg_retransmissions1, std_retransmissions1, avg_retransmission_ratio1, std_retransmission_ratio1,
+			avg_latency1, std_latency1, avg_queueing_delay1, std_queueing_delay1, avg_drops1, std_drops1,
+			avg_throughput2, std_throughput2, avg_goodput2, std_goodput2, avg_retransmissions2,
+			std_retransmissions2, avg_retransmission_ratio2, std_retransmission_ratio2, avg_latency2,
+			std_latency2, avg_queueing_delay2, std_queueing_delay2, avg_drops2, std_drops2,
+			avg_fairness, std_fairness, avg_max_min_ratio, std_max_min_ratio, avg_windowed_fairness,
+			std_windowed_fairness}
+		for _, shares := range cumul_shares {
+			result = append(result, pkg.Mean(shares), pkg.StdDev(shares))
+		}
+		results = append(results, result)
 
 		end := time.Since(start).Round(time.Second)
 		fmt.Printf("Finished %s/%s with rate %d in %s\n", suffix1, suffix2, rate, end)
@@ -164,8 +318,8 @@ func Experiment02(wg *sync.WaitGroup, combo []string) {
 	}
 }
 
-// Run Simulation 2 using ns2 and return a slice of traces. CBR always starts at t=0 here.
-func Simulation02(agent1 string, agent2 string, tcp2_start float64, cbr_rate float64) []*pkg.Trace {
+// Run Simulation 2 using ns2 and return the name of its trace file. CBR always starts at t=0 here.
+func Simulation02(agent1 string, agent2 string, tcp2_start float64, cbr_rate float64) string {
 	split1 := strings.Split(agent1, "/")
 	suffix1 := split1[len(split1)-1]
 	split2 := strings.Split(agent2, "/")
@@ -181,11 +335,35 @@ func Simulation02(agent1 string, agent2 string, tcp2_start float64, cbr_rate flo
 	if err != nil {
 		panic(err)
 	}
+	return filename
+}
 
-	traces, err := pkg.ParseTraceFile(filename)
+// Stream a trial's trace through 'visit' and delete it. With -keep the trace
+// is first archived gzipped, named after the trace file plus 'tag'
+func ScanTrial(filename string, tag string, visit func(*pkg.Trace)) {
+	// Skip malformed lines, but warn that the trial may not be trustworthy
+	skipped, err := pkg.ScanTraceFileLenient(filename, visit)
 	if err != nil {
 		panic(err)
 	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d malformed lines in %s\n", skipped, filename)
+	}
+	if *keep_traces {
+		pwd, _ := os.Getwd()
+		archive := filepath.Dir(pwd) + "/results/exp02/traces/" + strings.TrimSuffix(filename, ".tr") + tag + ".tr.gz"
+		err = pkg.CompressFile(filename, archive)
+		if err != nil {
+			panic(err)
+		}
+	}
 	os.Remove(filename)
-	return traces
+}
+
+// Create the latency meter selected by the -latency flag
+func NewLatencyMeter(from_node int, to_node int, span pkg.TimeSpan) pkg.SeriesMeter {
+	if *latency_mode == "e2e" {
+		return pkg.NewDelayMeter(span)
+	}
+	return pkg.NewLatencyMeter(from_node, to_node, span)
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/csv"
+	"flag"
 	"fmt"
 	"math"
 	"os"
@@ -15,7 +16,20 @@ import (
 	"github.com/DennisPing/Performance-Analysis-TCP-Variants/pkg"
 )
 
+// Keep each trial's trace as a gzipped artifact instead of deleting it
+var keep_traces = flag.Bool("keep", false, "keep each trial's trace gzipped in results/exp03/traces")
+
+// Drop the first seconds of every flow so its start-up transient does not skew the averages
+var warmup = flag.Float64("warmup", 0, "ignore the first `seconds` of every flow")
+
+// Measure latency over the N2 -> N3 hop or end-to-end from each flow's source to its sink
+var latency_mode = flag.String("latency", "hop", "latency to measure: 'hop' for N2 -> N3 or 'e2e' for source to sink")
+
 func main() {
+	flag.Parse()
+	if *latency_mode != "hop" && *latency_mode != "e2e" {
+		panic("-latency must be hop or e2e")
+	}
 
 	RenoDropTail := []string{"Agent/TCP/Reno", "DropTail"}
 	RenoRED := []string{"Agent/TCP/Reno", "RED"}
@@ -33,6 +47,9 @@ func main() {
 	if _, err := os.Stat(basedir + "/results/exp03"); os.IsNotExist(err) {
 		os.Mkdir(basedir+"/results/exp03", 0777)
 	}
+	if _, err := os.Stat(basedir + "/results/exp03/traces"); *keep_traces && os.IsNotExist(err) {
+		os.Mkdir(basedir+"/results/exp03/traces", 0777)
+	}
 
 	wg := new(sync.WaitGroup)
 	wg.Add(len(combos))
@@ -66,56 +83,149 @@ func Experiment03(wg *sync.WaitGroup, combo []string) {
 	}
 	defer file.Close()
 
-	header := "avg_throughput1,std_throughput1,avg_latency1,std_latency1,avg_drops1,std_drops1," +
-		"avg_throughput2,std_throughput2,avg_latency2,std_latency2,avg_drops2,std_drops2\n"
+	header := "avg_throughput1,std_throughput1,avg_goodput1,std_goodput1,avg_retransmissions1,std_retransmissions1," +
+		"avg_retransmission_ratio1,std_retransmission_ratio1,avg_latency1,std_latency1,avg_queueing_delay1," +
+		"std_queueing_delay1,avg_drops1,std_drops1," +
+		"avg_throughput2,std_throughput2,avg_goodput2,std_goodput2,avg_retransmissions2,std_retransmissions2," +
+		"avg_retransmission_ratio2,std_retransmission_ratio2,avg_latency2,std_latency2,avg_queueing_delay2," +
+		"std_queueing_delay2,avg_drops2,std_drops2," +
+		"avg_queue,std_queue,avg_peak_queue,std_peak_queue\n"
 	file.WriteString(header)
 	file.Close()
 
+	// Find the TCP flow, the CBR flow and the bottleneck they share in the first trial's trace, which is
+	// then measured like any other. The bottleneck and its bandwidth come from the script's topology
+	topology, err := pkg.ReadNs2Topology("../ns2/simulation03.tcl")
+	if err != nil {
+		panic(err)
+	}
+	trace_file := Simulation03(agent, queue, 5.0)
+	flows, bottleneck, err := pkg.DiscoverTraceFile(trace_file, topology)
+	if err != nil {
+		panic(err)
+	}
+	tcp_flows, cbr_flows := pkg.SplitAckedFlows(flows)
+	if len(tcp_flows) != 1 || len(cbr_flows) != 1 {
+		panic(fmt.Sprintf("expected 1 TCP and 1 CBR flow in simulation03.tcl, found %d and %d",
+			len(tcp_flows), len(cbr_flows)))
+	}
+	tcp_flow := tcp_flows[0]
+	cbr_flow := cbr_flows[0]
+
+	// The queue file and its plot are labeled N2-N3, so that must be the link the queue monitor watches
+	if name := topology.LinkName(bottleneck); name != "n2->n3" {
+		panic("the queue is recorded for " + name + " but labeled N2-N3")
+	}
+
 	var results [][]float64
 
 	start := time.Now()
 	fmt.Printf("Starting %s with queue %s\n", suffix, queue)
 	cumul_throughputs1 := make([]float64, 0)
+	cumul_goodputs1 := make([]float64, 0)
+	cumul_retransmissions1 := make([]float64, 0)
+	cumul_retransmission_ratios1 := make([]float64, 0)
 	cumul_latencies1 := make([]float64, 0)
+	cumul_queueing_delays1 := make([]float64, 0)
 	cumul_drops1 := make([]float64, 0)
 
 	cumul_throughputs2 := make([]float64, 0)
+	cumul_goodputs2 := make([]float64, 0)
+	cumul_retransmissions2 := make([]float64, 0)
+	cumul_retransmission_ratios2 := make([]float64, 0)
 	cumul_latencies2 := make([]float64, 0)
+	cumul_queueing_delays2 := make([]float64, 0)
 	cumul_drops2 := make([]float64, 0)
 
+	cumul_queues := make([]float64, 0)
+	cumul_peak_queues := make([]float64, 0)
+
 	// Simulation variables
-	from_node := 1 // ns2 counts from 0, so this is N2 -> N3
-	to_node := 2
+	from_node := bottleneck.From
+	to_node := bottleneck.To
+	bandwidth := topology.Bandwidths[bottleneck]
 
 	// TCP starts at t=0, let it stabilize, then start CBR at t=5
 	for cbr_start := 5.0; cbr_start <= 10.0; cbr_start += 0.1 {
-		traces := Simulation03(agent, queue, cbr_start)
-
-		// Prepare the trace data
-		tcpTraces := pkg.FilterByType(traces, "tcp")
-		cbrTraces := pkg.FilterByType(traces, "cbr")
-		tcpTraces = pkg.FilterByFid(tcpTraces, 1)
-		cbrTraces = pkg.FilterByFid(cbrTraces, 2)
+		if trace_file == "" { // The first trial already ran to discover the flows
+			trace_file = Simulation03(agent, queue, cbr_start)
+		}
 
-		// Calculate throughput, latency, and dropped packets
+		// Calculate throughput, latency, and dropped packets in a single pass
 		window_size := 0.2
-		time_ticks1, throughput_ticks1, throughput1 := pkg.CalculateThroughput(tcpTraces, from_node, to_node, 0.0, window_size)
-		_, _, latency1 := pkg.CalculateLatency(tcpTraces, from_node, to_node, 0.0)
-		drops1 := pkg.CountDrops(tcpTraces)
-
-		time_ticks2, throughput_ticks2, throughput2 := pkg.CalculateThroughput(cbrTraces, from_node, to_node, cbr_start, window_size)
-		_, _, latency2 := pkg.CalculateLatency(cbrTraces, from_node, to_node, cbr_start)
-		drops2 := pkg.CountDrops(cbrTraces)
+		span1 := pkg.WarmupSpan(0.0, *warmup)
+		throughput_meter1 := pkg.NewThroughputMeter(from_node, to_node, span1, window_size)
+		latency_meter1 := NewLatencyMeter(from_node, to_node, span1)
+		queueing_meter1 := pkg.NewDelayBreakdownMeter(from_node, to_node, bandwidth, span1)
+		drop_counter1 := pkg.NewDropCounter(span1)
+		goodput_meter1 := pkg.NewGoodputMeter(span1)
+
+		span2 := pkg.WarmupSpan(cbr_start, *warmup)
+		throughput_meter2 := pkg.NewThroughputMeter(from_node, to_node, span2, window_size)
+		latency_meter2 := NewLatencyMeter(from_node, to_node, span2)
+		queueing_meter2 := pkg.NewDelayBreakdownMeter(from_node, to_node, bandwidth, span2)
+		drop_counter2 := pkg.NewDropCounter(span2)
+		goodput_meter2 := pkg.NewGoodputMeter(span2)
+
+		// The queue is shared by both flows, so it sees every trace on the link
+		queue_monitor := pkg.NewQueueMonitor(from_node, to_node, span1)
+
+		is_flow1 := pkg.And(pkg.TypeFilter(tcp_flow.DataType()), pkg.FidFilter(tcp_flow.Fid))
+		is_flow2 := pkg.And(pkg.TypeFilter(cbr_flow.DataType()), pkg.FidFilter(cbr_flow.Fid))
+		tag := "_cbr" + strconv.FormatFloat(cbr_start, 'f', 1, 64)
+		ScanTrial(trace_file, tag, func(trace *pkg.Trace) {
+			queue_monitor.Add(trace)
+			if is_flow1(trace) {
+				throughput_meter1.Add(trace)
+				latency_meter1.Add(trace)
+				queueing_meter1.Add(trace)
+				drop_counter1.Add(trace)
+				goodput_meter1.Add(trace)
+			} else if is_flow2(trace) {
+				throughput_meter2.Add(trace)
+				latency_meter2.Add(trace)
+				queueing_meter2.Add(trace)
+				drop_counter2.Add(trace)
+				goodput_meter2.Add(trace)
+			}
+		})
+		trace_file = ""
+
+		time_ticks1, throughput_ticks1, throughput1 := throughput_meter1.Result()
+		_, _, latency1 := latency_meter1.Result()
+		_, _, queueing_delay1 := queueing_meter1.Result()
+		drops1 := drop_counter1.Result()
+		goodput1, retransmissions1, retransmission_ratio1 := goodput_meter1.Result()
+
+		time_ticks2, throughput_ticks2, throughput2 := throughput_meter2.Result()
+		_, _, latency2 := latency_meter2.Result()
+		_, _, queueing_delay2 := queueing_meter2.Result()
+		drops2 := drop_counter2.Result()
+		goodput2, retransmissions2, retransmission_ratio2 := goodput_meter2.Result()
+
+		queue_ticks, occupancy_ticks, occupancy := queue_monitor.Result()
+		peak_occupancy, _ := queue_monitor.Peak()
 
 		// Add the results to the cumulative results
 		cumul_throughputs1 = append(cumul_throughputs1, throughput1)
+		cumul_goodputs1 = append(cumul_goodputs1, goodput1)
+		cumul_retransmissions1 = append(cumul_retransmissions1, float64(retransmissions1))
+		cumul_retransmission_ratios1 = append(cumul_retransmission_ratios1, retransmission_ratio1)
 		cumul_latencies1 = append(cumul_latencies1, latency1)
+		cumul_queueing_delays1 = append(cumul_queueing_delays1, queueing_delay1)
 		cumul_drops1 = append(cumul_drops1, float64(drops1))
 
 		cumul_throughputs2 = append(cumul_throughputs2, throughput2)
+		cumul_goodputs2 = append(cumul_goodputs2, goodput2)
+		cumul_retransmissions2 = append(cumul_retransmissions2, float64(retransmissions2))
+		cumul_retransmission_ratios2 = append(cumul_retransmission_ratios2, retransmission_ratio2)
 		cumul_latencies2 = append(cumul_latencies2, latency2)
+		cumul_queueing_delays2 = append(cumul_queueing_delays2, queueing_delay2)
 		cumul_drops2 = append(cumul_drops2, float64(drops2))
 
+		cumul_queues = append(cumul_queues, occupancy)
+		cumul_peak_queues = append(cumul_peak_queues, float64(peak_occupancy))
+
 		// Record the time vs throughput for t=10 specifically
 		if math.Abs(cbr_start-10.0) < 0.001 {
 			fname1 := basedir + "/results/exp03/exp03_" + suffix + "_" + queue + "_TCP.csv"
@@ -123,6 +233,9 @@ func Experiment03(wg *sync.WaitGroup, combo []string) {
 
 			fname2 := basedir + "/results/exp03/exp03_" + suffix + "_" + queue + "_CBR.csv"
 			pkg.Record(time_ticks2, throughput_ticks2, "time_ticks", "throughput_ticks", fname2)
+
+			fname3 := basedir + "/results/exp03/exp03_" + suffix + "_" + queue + "_Queue.csv"
+			pkg.Record(queue_ticks, occupancy_ticks, "time_ticks", "queue_packets", fname3)
 		}
 	}
 
@@ -131,18 +244,44 @@ func Experiment03(wg *sync.WaitGroup, combo []string) {
 	avg_drops1 := pkg.Mean(cumul_drops1)
 	std_throughput1 := pkg.StdDev(cumul_throughputs1)
 	std_latency1 := pkg.StdDev(cumul_latencies1)
+	avg_queueing_delay1 := pkg.Mean(cumul_queueing_delays1)
+	std_queueing_delay1 := pkg.StdDev(cumul_queueing_delays1)
 	std_drops1 := pkg.StdDev(cumul_drops1)
+	avg_goodput1 := pkg.Mean(cumul_goodputs1)
+	std_goodput1 := pkg.StdDev(cumul_goodputs1)
+	avg_retransmissions1 := pkg.Mean(cumul_retransmissions1)
+	std_retransmissions1 := pkg.StdDev(cumul_retransmissions1)
+	avg_retransmission_ratio1 := pkg.Mean(cumul_retransmission_ratios1)
+	std_retransmission_ratio1 := pkg.StdDev(cumul_retransmission_ratios1)
 
 	avg_throughput2 := pkg.Mean(cumul_throughputs2)
 	avg_latency2 := pkg.Mean(cumul_latencies2)
 	avg_drops2 := pkg.Mean(cumul_drops2)
 	std_throughput2 := pkg.StdDev(cumul_throughputs2)
 	std_latency2 := pkg.StdDev(cumul_latencies2)
+	avg_queueing_delay2 := pkg.Mean(cumul_queueing_delays2)
+	std_queueing_delay2 := pkg.StdDev(cumul_queueing_delays2)
 	std_drops2 := pkg.StdDev(cumul_drops2)
+	avg_goodput2 := pkg.Mean(cumul_goodputs2)
+	std_goodput2 := pkg.StdDev(cumul_goodputs2)
+	avg_retransmissions2 := pkg.Mean(cumul_retransmissions2)
+	std_retransmissions2 := pkg.StdDev(cumul_retransmissions2)
+	avg_retransmission_ratio2 := pkg.Mean(cumul_retransmission_ratios2)
+	std_retransmission_ratio2 := pkg.StdDev(cumul_retransmission_ratios2)
+
+	avg_queue := pkg.Mean(cumul_queues)
+	std_queue := pkg.StdDev(cumul_queues)
+	avg_peak_queue := pkg.Mean(cumul_peak_queues)
+	std_peak_queue := pkg.StdDev(cumul_peak_queues)
 
 	results = append(results,
-		[]float64{avg_throughput1, std_throughput1, avg_latency1, std_latency1, avg_drops1, std_drops1,
-			avg_throughput2, std_throughput2, avg_latency2, std_latency2, avg_drops2, std_drops2})
+		[]float64{avg_throughput1, std_throughput1, avg_goodput1, std_goodput1, avg_retransmissions1,
+			std_retransmissions1, avg_retransmission_ratio1, std_retransmission_ratio1, avg_latency1, std_latency1,
+			avg_queueing_delay1, std_queueing_delay1, avg_drops1, std_drops1,
+			avg_throughput2, std_throughput2, avg_goodput2, std_goodput2, avg_retransmissions2, std_retransmissions2,
+			avg_retransmission_ratio2, std_retransmission_ratio2, avg_latency2, std_latency2, avg_queueing_delay2,
+			std_queueing_delay2, avg_drops2, std_drops2,
+			avg_queue, std_queue, avg_peak_queue, std_peak_queue})
 
 	end := time.Since(start).Round(time.Second)
 	fmt.Printf("Finished %s with queue %s in %s\n", suffix, queue, end)
@@ -165,8 +304,8 @@ func Experiment03(wg *sync.WaitGroup, combo []string) {
 	}
 }
 
-// Run Simulation 3 using ns2 and return a slice of traces. CBR start time varies.
-func Simulation03(agent string, queue string, cbr_start float64) []*pkg.Trace {
+// Run Simulation 3 using ns2 and return the name of its trace file. CBR start time varies.
+func Simulation03(agent string, queue string, cbr_start float64) string {
 	split := strings.Split(agent, "/")
 	suffix := split[len(split)-1]
 	filename := "outfile_" + suffix + "_" + queue + ".tr"
@@ -180,11 +319,35 @@ func Simulation03(agent string, queue string, cbr_start float64) []*pkg.Trace {
 	if err != nil {
 		panic(err)
 	}
+	return filename
+}
 
-	traces, err := pkg.ParseTraceFile(filename)
+// Stream a trial's trace through 'visit' and delete it. With -keep the trace
+// is first archived gzipped, named after the trace file plus 'tag'
+func ScanTrial(filename string, tag string, visit func(*pkg.Trace)) {
+	// Skip malformed lines, but warn that the trial may not be trustworthy
+	skipped, err := pkg.ScanTraceFileLenient(filename, visit)
 	if err != nil {
 		panic(err)
 	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d malformed lines in %s\n", skipped, filename)
+	}
+	if *keep_traces {
+		pwd, _ := os.Getwd()
+		archive := filepath.Dir(pwd) + "/results/exp03/traces/" + strings.TrimSuffix(filename, ".tr") + tag + ".tr.gz"
+		err = pkg.CompressFile(filename, archive)
+		if err != nil {
+			panic(err)
+		}
+	}
 	os.Remove(filename)
-	return traces
+}
+
+// Create the latency meter selected by the -latency flag
+func NewLatencyMeter(from_node int, to_node int, span pkg.TimeSpan) pkg.SeriesMeter {
+	if *latency_mode == "e2e" {
+		return pkg.NewDelayMeter(span)
+	}
+	return pkg.NewLatencyMeter(from_node, to_node, span)
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/DennisPing/Performance-Analysis-TCP-Variants/pkg"
+)
+
+// Write the traces that match a filter expression, for example
+//
+//	tracefilter 'event==r && link==1->2 && fid in {1,2} && time>=5' outfile.tr
+var (
+	output  = flag.String("o", "", "write the matching traces to this file instead of stdout")
+	lenient = flag.Bool("lenient", false, "skip malformed lines instead of stopping at the first one")
+	cached  = flag.Bool("cache", false, "load the trace through <trace>.cache, writing the cache on the first run")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-o file] [-lenient | -cache] 'expression' trace.tr\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	filter, err := pkg.ParseFilter(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *cached && *lenient {
+		fmt.Fprintln(os.Stderr, "-cache cannot be combined with -lenient")
+		os.Exit(2)
+	}
+	source, err := pkg.OpenTraceSource(flag.Arg(1), *cached, *lenient)
+	if err != nil {
+		panic(err)
+	}
+	defer source.Close()
+
+	var writer *pkg.TraceWriter
+	if *output == "" {
+		writer = pkg.NewTraceWriter(os.Stdout)
+	} else {
+		writer, err = pkg.CreateTraceFile(*output)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	matched := 0
+	for source.Next() {
+		trace := source.Trace()
+		if !filter(trace) {
+			continue
+		}
+		matched++
+		if err := writer.Write(trace); err != nil {
+			panic(err)
+		}
+	}
+	if err := source.Err(); err != nil {
+		panic(err)
+	}
+	if err := writer.Close(); err != nil {
+		panic(err)
+	}
+	if source.Skipped() > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d malformed lines in %s\n", source.Skipped(), flag.Arg(1))
+	}
+	fmt.Fprintf(os.Stderr, "Matched %d traces\n", matched)
+}
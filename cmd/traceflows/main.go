@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/DennisPing/Performance-Analysis-TCP-Variants/pkg"
+)
+
+// Print a CSV summary of every flow in a trace, for example
+//
+//	traceflows outfile.tr
+var (
+	output  = flag.String("o", "", "write the summary to this file instead of stdout")
+	lenient = flag.Bool("lenient", false, "skip malformed lines instead of stopping at the first one")
+	cached  = flag.Bool("cache", false, "load the trace through <trace>.cache, writing the cache on the first run")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-o file] [-lenient | -cache] trace.tr\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *cached && *lenient {
+		fmt.Fprintln(os.Stderr, "-cache cannot be combined with -lenient")
+		os.Exit(2)
+	}
+	source, err := pkg.OpenTraceSource(flag.Arg(0), *cached, *lenient)
+	if err != nil {
+		panic(err)
+	}
+	defer source.Close()
+
+	discovery := pkg.NewFlowDiscovery()
+	err = pkg.ScanTraces(source, discovery.Add)
+	if err != nil {
+		panic(err)
+	}
+	if source.Skipped() > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d malformed lines in %s\n", source.Skipped(), flag.Arg(0))
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			panic(err)
+		}
+		defer out.Close()
+	}
+	flows := discovery.Result()
+	err = pkg.WriteFlowSummaries(out, flows)
+	if err != nil {
+		panic(err)
+	}
+	for _, link := range pkg.SharedLinks(flows) {
+		fmt.Fprintf(os.Stderr, "Every flow traverses %d->%d\n", link.From, link.To)
+	}
+}
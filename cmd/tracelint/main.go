@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/DennisPing/Performance-Analysis-TCP-Variants/pkg"
+)
+
+// Check ns2 traces for causal invariants and exit with status 1 on any violation, for example
+//
+//	tracelint outfile.tr
+var max_issues = flag.Int("max", 50, "print at most this many issues per file, or all of them if 0")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-max n] trace.tr...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, file := range flag.Args() {
+		issues, err := pkg.LintTraceFile(file)
+		for i, issue := range issues {
+			if *max_issues > 0 && i == *max_issues {
+				fmt.Printf("%s: %d more issues not shown\n", file, len(issues)-i)
+				break
+			}
+			fmt.Printf("%s:%d: %s\n", file, issue.Line, issue.Msg)
+		}
+		if err != nil {
+			fmt.Println(err)
+			failed = true
+		}
+		if len(issues) > 0 {
+			fmt.Printf("%s: %d issues\n", file, len(issues))
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
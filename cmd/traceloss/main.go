@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/DennisPing/Performance-Analysis-TCP-Variants/pkg"
+)
+
+// Print a CSV loss report of every flow in a trace, for example
+//
+//	traceloss -start 2 outfile.tr
+var (
+	output  = flag.String("o", "", "write the report to this file instead of stdout")
+	lenient = flag.Bool("lenient", false, "skip malformed lines instead of stopping at the first one")
+	cached  = flag.Bool("cache", false, "load the trace through <trace>.cache, writing the cache on the first run")
+	start   = flag.Float64("start", 0, "only follow packets sent from this `time` on")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-o file] [-lenient | -cache] [-start time] trace.tr\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *cached && *lenient {
+		fmt.Fprintln(os.Stderr, "-cache cannot be combined with -lenient")
+		os.Exit(2)
+	}
+	source, err := pkg.OpenTraceSource(flag.Arg(0), *cached, *lenient)
+	if err != nil {
+		panic(err)
+	}
+	defer source.Close()
+
+	// ACKs share the fid of their flow, so leave them out of the flow's losses
+	span := pkg.AbsoluteSpan(*start, math.Inf(1))
+	meters := make(map[int]*pkg.LossMeter)
+	err = pkg.ScanTraces(source, func(trace *pkg.Trace) {
+		if trace.Type == pkg.Ack {
+			return
+		}
+		meter, ok := meters[trace.Fid]
+		if !ok {
+			meter = pkg.NewLossMeter(span)
+			meters[trace.Fid] = meter
+		}
+		meter.Add(trace)
+	})
+	if err != nil {
+		panic(err)
+	}
+	if source.Skipped() > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d malformed lines in %s\n", source.Skipped(), flag.Arg(0))
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			panic(err)
+		}
+		defer out.Close()
+	}
+	reports := make(map[int]*pkg.LossReport, len(meters))
+	for fid, meter := range meters {
+		reports[fid] = meter.Result()
+	}
+	err = pkg.WriteLossReports(out, reports)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -35,6 +35,16 @@ def plotThroughput(fig: plt.Figure, ax: plt.Axes, df: pd.DataFrame, agent_name:
     ax.set_ylabel('TCP Throughput (Mbps)')
     ax.set_yticks(np.arange(0, 10, 1))
     fig.savefig(f"{save_dir}/exp01_throughput.png")
+
+def plotUtilization(fig: plt.Figure, ax: plt.Axes, df: pd.DataFrame, agent_name: str, color: str, save_dir: str):
+    ax.plot(df["cbr_rate"], df["avg_utilization"]*100, marker='o', label=agent_name, color=color)
+    # Shade in standard deviation
+    ax.fill_between(df["cbr_rate"], df["avg_utilization"]*100 - df["std_utilization"]*100, df["avg_utilization"]*100 + df["std_utilization"]*100, alpha=0.25, color=color)
+    ax.legend()
+    ax.set_title("N2-N3 Link Utilization vs. CBR Rate")
+    ax.set_xlabel('CBR Rate (Mbps)')
+    ax.set_ylabel('Link Utilization (%)')
+    fig.savefig(f"{save_dir}/exp01_utilization.png")
     
 
 def main():
@@ -46,6 +56,7 @@ def main():
     fig1, ax1 = plt.subplots()
     fig2, ax2 = plt.subplots()
     fig3, ax3 = plt.subplots()
+    fig4, ax4 = plt.subplots()
 
     dir = "../results/exp01"
     csvfiles = ["exp01_Tahoe.csv", "exp01_Reno.csv", "exp01_Newreno.csv", "exp01_Vegas.csv"]
@@ -71,6 +82,11 @@ def main():
             plotDrops(fig1, ax1, df, agent_name, colorMap[agent_name], dir)
             plotLatency(fig2, ax2, df, agent_name, colorMap[agent_name], dir)
             plotThroughput(fig3, ax3, df, agent_name, colorMap[agent_name], dir)
+            # Results from before the utilization meter have no utilization columns
+            if {"avg_utilization", "std_utilization"}.issubset(df.columns):
+                plotUtilization(fig4, ax4, df, agent_name, colorMap[agent_name], dir)
+            else:
+                print(f"Skipping {agent_name} utilization plot, rerun exp01 to measure it")
 
 if __name__ == "__main__":
     main()
\ No newline at end of file
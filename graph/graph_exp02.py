@@ -46,6 +46,20 @@ def plotThroughput(df: pd.DataFrame, agent1: str, agent2: str, color1: str, colo
     ax.set_ylabel('TCP Throughput (Mbps)')
     ax.set_yticks(np.arange(0, 10, 1))
     fig.savefig(f"{save_dir}/exp02_{agent1}_{agent2}_throughput.png")
+
+def plotFairness(df: pd.DataFrame, agent1: str, agent2: str, color1: str, color2: str, save_dir: str):
+    fig, ax = plt.subplots()
+    ax.plot(df["cbr_rate"], df["avg_jain_index"], marker='o', label="Trial average", color=color1)
+    ax.plot(df["cbr_rate"], df["avg_windowed_jain_index"], marker='o', label="Sliding window", color=color2)
+    # Shade in standard deviation
+    ax.fill_between(df["cbr_rate"], df["avg_jain_index"] - df["std_jain_index"], df["avg_jain_index"] + df["std_jain_index"], alpha=0.25, color=color1)
+    ax.fill_between(df["cbr_rate"], df["avg_windowed_jain_index"] - df["std_windowed_jain_index"], df["avg_windowed_jain_index"] + df["std_windowed_jain_index"], alpha=0.25, color=color2)
+    ax.legend(loc='lower left')
+    ax.set_title(agent1 + "/" + agent2 + " Jain's Fairness Index vs. CBR Rate")
+    ax.set_xlabel('CBR Rate (Mbps)')
+    ax.set_ylabel("Jain's Fairness Index")
+    ax.set_ylim(0.5, 1.02)
+    fig.savefig(f"{save_dir}/exp02_{agent1}_{agent2}_fairness.png")
     
 
 def main():
@@ -86,6 +100,11 @@ def main():
             plotDrops(df, agent1, agent2, color1, color2, dir)
             plotLatency(df, agent1, agent2, color1, color2, dir)
             plotThroughput(df, agent1, agent2, color1, color2, dir)
+            # Results from before the fairness metrics have no fairness columns
+            if {"avg_jain_index", "std_jain_index", "avg_windowed_jain_index", "std_windowed_jain_index"}.issubset(df.columns):
+                plotFairness(df, agent1, agent2, color1, color2, dir)
+            else:
+                print(f"Skipping {agent1}/{agent2} fairness plot, rerun exp02 to measure it")
 
 if __name__ == "__main__":
     main()
\ No newline at end of file
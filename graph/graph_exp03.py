@@ -46,6 +46,26 @@ def main():
             ax.set_title(f"{agent}/{queue} Throughput over Time")
             fig.savefig(f"{dir}/{agent}_{queue}_trace.png")
 
+    # Queue occupancy of the N2 -> N3 bottleneck, DropTail vs RED
+    # Results from before the queue monitor have no queue files, so skip them
+    for agent in ["Reno", "Sack1"]:
+        queuefiles = [(queue, color, os.path.join(dir, f"exp03_{agent}_{queue}_Queue.csv"))
+                      for queue, color in [("DropTail", 'tab:red'), ("RED", 'tab:blue')]]
+        queuefiles = [(queue, color, path) for queue, color, path in queuefiles if os.path.exists(path)]
+        if not queuefiles:
+            print(f"Skipping {agent} queue plot, rerun exp03 to record the queue")
+            continue
+        fig, ax = plt.subplots()
+        for queue, color, path in queuefiles:
+            df = pd.read_csv(path)
+            ax.step(df['time_ticks'], df['queue_packets'], where='post', color=color, label=queue)
+
+        ax.legend()
+        ax.set_xlabel('Time (s)')
+        ax.set_ylabel('Queue Length (packets)')
+        ax.set_title(f"{agent} N2-N3 Queue Occupancy over Time")
+        fig.savefig(f"{dir}/{agent}_queue.png")
+
             
 
 if __name__ == "__main__":
@@ -0,0 +1,117 @@
+package pkg
+
+import "math"
+
+// DelayBreakdown splits the delay of a packet over a link into its
+// components, all in seconds. ns2 logs 'r' when the last bit arrives, so the
+// time from '-' to 'r' is the transmission plus the propagation delay
+type DelayBreakdown struct {
+	Queueing     float64 // Waiting in the queue, from '+' to '-'
+	Transmission float64 // Putting the packet on the wire, from its size and the link bandwidth
+	Propagation  float64 // The rest of the time from '-' to 'r'
+}
+
+// Get the total delay over the link
+func (d DelayBreakdown) Total() float64 {
+	return d.Queueing + d.Transmission + d.Propagation
+}
+
+// Break down the delay of a completed hop for a packet of 'size' bytes on a
+// link of 'bandwidth' bits per second
+func breakDown(hop *Hop, size int, bandwidth float64) DelayBreakdown {
+	transmission := float64(size) * 8 / bandwidth
+	return DelayBreakdown{
+		Queueing:     hop.QueueDelay(),
+		Transmission: transmission,
+		Propagation:  hop.LinkDelay() - transmission,
+	}
+}
+
+// DelayBreakdownMeter breaks down the delay of every packet over a single
+// link in a single pass over a trace, from the reconstructed packet journeys
+type DelayBreakdownMeter struct {
+	link      Link
+	bandwidth float64
+	span      TimeSpan
+	journeys  *JourneyBuilder
+
+	time_ticks         []float64
+	queueing_ticks     []float64
+	transmission_ticks []float64
+	propagation_ticks  []float64
+}
+
+// Create a DelayBreakdownMeter for the link 'from_node' -> 'to_node' of
+// 'bandwidth' bits per second that only records packets received inside 'span'
+func NewDelayBreakdownMeter(from_node int, to_node int, bandwidth float64, span TimeSpan) *DelayBreakdownMeter {
+	m := &DelayBreakdownMeter{link: Link{From: from_node, To: to_node}, bandwidth: bandwidth, span: span, journeys: NewJourneyBuilder()}
+	m.journeys.OnHop(m.addHop)
+	return m
+}
+
+// Add the next trace. Traces must arrive in time order
+func (m *DelayBreakdownMeter) Add(trace *Trace) {
+	m.journeys.Add(trace)
+}
+
+func (m *DelayBreakdownMeter) addHop(journey *Journey, hop *Hop) {
+	if hop.Link != m.link || math.IsNaN(hop.Enqueue) || math.IsNaN(hop.Dequeue) || !m.span.Contains(hop.Receive) {
+		return
+	}
+	delay := breakDown(hop, journey.Size, m.bandwidth)
+	m.time_ticks = append(m.time_ticks, hop.Receive)
+	m.queueing_ticks = append(m.queueing_ticks, delay.Queueing)
+	m.transmission_ticks = append(m.transmission_ticks, delay.Transmission)
+	m.propagation_ticks = append(m.propagation_ticks, delay.Propagation)
+}
+
+// Return slice times, slice queueing delays, and average queueing delay
+func (m *DelayBreakdownMeter) Result() ([]float64, []float64, float64) {
+	return m.time_ticks, m.queueing_ticks, Mean(m.queueing_ticks)
+}
+
+// Return slice times, slice queueing delays, slice transmission delays, and slice propagation delays
+func (m *DelayBreakdownMeter) Series() ([]float64, []float64, []float64, []float64) {
+	return m.time_ticks, m.queueing_ticks, m.transmission_ticks, m.propagation_ticks
+}
+
+// Get the average of each delay component
+func (m *DelayBreakdownMeter) Average() DelayBreakdown {
+	return DelayBreakdown{
+		Queueing:     Mean(m.queueing_ticks),
+		Transmission: Mean(m.transmission_ticks),
+		Propagation:  Mean(m.propagation_ticks),
+	}
+}
+
+// Get the average delay breakdown of every link in a trace inside 'span'.
+// 'bandwidths' holds the bits per second of each link, and links that are
+// not in it are left out since their transmission delay is unknown
+func CalculateDelayBreakdowns(traces []*Trace, bandwidths map[Link]float64, span TimeSpan) map[Link]DelayBreakdown {
+	sums := make(map[Link]DelayBreakdown)
+	counts := make(map[Link]int)
+	builder := NewJourneyBuilder()
+	builder.OnHop(func(journey *Journey, hop *Hop) {
+		bandwidth, ok := bandwidths[hop.Link]
+		if !ok || math.IsNaN(hop.Enqueue) || math.IsNaN(hop.Dequeue) || !span.Contains(hop.Receive) {
+			return
+		}
+		delay := breakDown(hop, journey.Size, bandwidth)
+		sum := sums[hop.Link]
+		sum.Queueing += delay.Queueing
+		sum.Transmission += delay.Transmission
+		sum.Propagation += delay.Propagation
+		sums[hop.Link] = sum
+		counts[hop.Link]++
+	})
+	for _, trace := range traces {
+		builder.Add(trace)
+	}
+
+	breakdowns := make(map[Link]DelayBreakdown, len(sums))
+	for link, sum := range sums {
+		n := float64(counts[link])
+		breakdowns[link] = DelayBreakdown{Queueing: sum.Queueing / n, Transmission: sum.Transmission / n, Propagation: sum.Propagation / n}
+	}
+	return breakdowns
+}
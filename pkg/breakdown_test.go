@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"math"
+	"testing"
+)
+
+// Report whether every component of a breakdown is within 1ns of the wanted one
+func sameBreakdown(got DelayBreakdown, want DelayBreakdown) bool {
+	return math.Abs(got.Queueing-want.Queueing) < 1e-9 && math.Abs(got.Transmission-want.Transmission) < 1e-9 &&
+		math.Abs(got.Propagation-want.Propagation) < 1e-9
+}
+
+func TestDelayBreakdownMeter(t *testing.T) {
+	// Only pid 0 completes 1->2, after 20ms in the queue and 10ms from '-' to
+	// 'r'. 1000 bytes at 2 Mbps take 4ms to transmit, so 6ms are propagation
+	meter := NewDelayBreakdownMeter(1, 2, 2e6, FullSpan())
+	for _, trace := range parseTestTraces(t, journeyTrace) {
+		meter.Add(trace)
+	}
+	times, queueing, transmission, propagation := meter.Series()
+	checkSeries(t, "queueing", times, queueing, []float64{0.14}, []float64{0.02})
+	checkSeries(t, "transmission", times, transmission, []float64{0.14}, []float64{0.004})
+	checkSeries(t, "propagation", times, propagation, []float64{0.14}, []float64{0.006})
+	average := meter.Average()
+	if !sameBreakdown(average, DelayBreakdown{0.02, 0.004, 0.006}) || math.Abs(average.Total()-0.03) > 1e-9 {
+		t.Errorf("average = %+v with total %g, want 20ms, 4ms and 6ms with total 30ms", average, average.Total())
+	}
+}
+
+func TestCalculateDelayBreakdowns(t *testing.T) {
+	// Each link has its own bandwidth, and 2->3 is left out since its bandwidth is unknown
+	bandwidths := map[Link]float64{{0, 1}: 1e6, {1, 2}: 2e6}
+	breakdowns := CalculateDelayBreakdowns(parseTestTraces(t, journeyTrace), bandwidths, FullSpan())
+	want := map[Link]DelayBreakdown{
+		{0, 1}: {Queueing: 0, Transmission: 0.008, Propagation: 0.002}, // All 3 packets
+		{1, 2}: {Queueing: 0.02, Transmission: 0.004, Propagation: 0.006},
+	}
+	if len(breakdowns) != len(want) {
+		t.Fatalf("breakdowns = %v, want %v", breakdowns, want)
+	}
+	for link, breakdown := range want {
+		if !sameBreakdown(breakdowns[link], breakdown) {
+			t.Errorf("%v: breakdown = %+v, want %+v", link, breakdowns[link], breakdown)
+		}
+	}
+
+	// A packet received exactly at the end of the span is outside it
+	breakdowns = CalculateDelayBreakdowns(parseTestTraces(t, journeyTrace), bandwidths, AbsoluteSpan(0, 0.14))
+	if _, ok := breakdowns[Link{1, 2}]; ok {
+		t.Errorf("span [0, 0.14): 1->2 = %+v, want no packets", breakdowns[Link{1, 2}])
+	}
+}
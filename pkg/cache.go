@@ -0,0 +1,472 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The trace cache file layout, all little endian:
+//
+//	magic   [4]byte  "NSTC"
+//	version uint16
+//	_       uint16
+//	count   uint64   The number of traces
+//	ntypes  uint16   The number of packet type names, followed by each name
+//	                 as a uint16 length and its bytes
+//
+// followed by one column per field, each holding 'count' values in the order
+// events (uint8), times (float64), from, to (int32), types (uint16),
+// sizes (int32), flags (uint8), fids, src nodes, src ports, dst nodes,
+// dst ports (int32), seqs, pids (int64), and then
+//
+//	ntexts  uint64   The number of original time texts, followed by each one
+//	                 as a uint64 row, a uint16 length and its bytes
+const (
+	traceCacheMagic   = "NSTC"
+	traceCacheVersion = 2
+)
+
+var (
+	errTraceCache   = errors.New("not a trace cache file")
+	errTooManyTypes = errors.New("trace table holds more than 65535 packet types")
+	errCacheLenient = errors.New("a cached trace is always parsed strictly, so it cannot be read leniently")
+)
+
+// TraceTable holds a trace in columns, one slice per Trace field. It is far
+// more compact than a slice of *Trace and is what the trace cache stores
+type TraceTable struct {
+	Events   []Event
+	Times    []float64
+	From     []int32
+	To       []int32
+	Types    []uint16     // Indexes into TypeName
+	TypeName []PacketType // The distinct packet types, at most 65535
+	Sizes    []int32
+	Flags    []Flags
+	Fids     []int32
+	SrcNodes []int32
+	SrcPorts []int32
+	DstNodes []int32
+	DstPorts []int32
+	Seqs     []int64
+	Pids     []int64
+
+	// The original time text of the rows whose time FormatFloat would not
+	// reproduce, so a cached trace is still written back byte for byte
+	TimeText map[int]string
+}
+
+// Create a TraceTable from a slice of traces
+func NewTraceTable(traces []*Trace) (*TraceTable, error) {
+	table := &TraceTable{}
+	for _, trace := range traces {
+		if err := table.Append(trace); err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+// Get the number of traces in the table
+func (t *TraceTable) Len() int {
+	return len(t.Events)
+}
+
+// Append a trace to the table
+func (t *TraceTable) Append(trace *Trace) error {
+	type_index := -1
+	for i, name := range t.TypeName {
+		if name == trace.Type {
+			type_index = i
+			break
+		}
+	}
+	if type_index < 0 {
+		if len(t.TypeName) == math.MaxUint16 {
+			return errTooManyTypes
+		}
+		type_index = len(t.TypeName)
+		t.TypeName = append(t.TypeName, trace.Type)
+	}
+
+	if trace.time_text != "" {
+		if t.TimeText == nil {
+			t.TimeText = make(map[int]string)
+		}
+		t.TimeText[t.Len()] = trace.time_text
+	}
+	t.Events = append(t.Events, trace.Event)
+	t.Times = append(t.Times, trace.Time)
+	t.From = append(t.From, int32(trace.From))
+	t.To = append(t.To, int32(trace.To))
+	t.Types = append(t.Types, uint16(type_index))
+	t.Sizes = append(t.Sizes, int32(trace.Size))
+	t.Flags = append(t.Flags, trace.Flags)
+	t.Fids = append(t.Fids, int32(trace.Fid))
+	t.SrcNodes = append(t.SrcNodes, int32(trace.Src.Node))
+	t.SrcPorts = append(t.SrcPorts, int32(trace.Src.Port))
+	t.DstNodes = append(t.DstNodes, int32(trace.Dst.Node))
+	t.DstPorts = append(t.DstPorts, int32(trace.Dst.Port))
+	t.Seqs = append(t.Seqs, int64(trace.Seq))
+	t.Pids = append(t.Pids, int64(trace.Pid))
+	return nil
+}
+
+// Get the i-th trace of the table
+func (t *TraceTable) Trace(i int) *Trace {
+	return &Trace{
+		Event: t.Events[i],
+		Time:  t.Times[i],
+		From:  int(t.From[i]),
+		To:    int(t.To[i]),
+		Type:  t.TypeName[t.Types[i]],
+		Size:  int(t.Sizes[i]),
+		Flags: t.Flags[i],
+		Fid:   int(t.Fids[i]),
+		Src:   Addr{Node: int(t.SrcNodes[i]), Port: int(t.SrcPorts[i])},
+		Dst:   Addr{Node: int(t.DstNodes[i]), Port: int(t.DstPorts[i])},
+		Seq:   int(t.Seqs[i]),
+		Pid:   int(t.Pids[i]),
+
+		time_text: t.TimeText[i],
+	}
+}
+
+// Get every trace of the table as a slice of Trace structs
+func (t *TraceTable) Traces() []*Trace {
+	traces := make([]*Trace, t.Len())
+	for i := range traces {
+		traces[i] = t.Trace(i)
+	}
+	return traces
+}
+
+// TableReader streams the traces of a TraceTable as a TraceSource
+type TableReader struct {
+	table *TraceTable
+	next  int
+	trace *Trace
+}
+
+// Create a TableReader over every trace of the table
+func (t *TraceTable) Reader() *TableReader {
+	return &TableReader{table: t}
+}
+
+// Advance to the next trace. Return false at the end of the table
+func (r *TableReader) Next() bool {
+	if r.next >= r.table.Len() {
+		return false
+	}
+	r.trace = r.table.Trace(r.next)
+	r.next++
+	return true
+}
+
+// Get the trace read by the last call to Next
+func (r *TableReader) Trace() *Trace {
+	return r.trace
+}
+
+// A table is already in memory, so reading it never fails
+func (r *TableReader) Err() error {
+	return nil
+}
+
+// A table only holds traces that parsed, so nothing is ever skipped
+func (r *TableReader) Skipped() int {
+	return 0
+}
+
+// A table is not backed by an open file, so there is nothing to close
+func (r *TableReader) Close() error {
+	return nil
+}
+
+// The number of bytes a single trace takes across all columns
+const traceCacheRowSize = 60
+
+// Write the table to a trace cache file
+func WriteTraceCache(file string, table *TraceTable) error {
+	buf := make([]byte, 0, 18+table.Len()*traceCacheRowSize)
+	buf = append(buf, traceCacheMagic...)
+	buf = appendUint16(buf, traceCacheVersion)
+	buf = appendUint16(buf, 0)
+	buf = appendUint64(buf, uint64(table.Len()))
+	buf = appendUint16(buf, uint16(len(table.TypeName)))
+	for _, name := range table.TypeName {
+		buf = appendUint16(buf, uint16(len(name)))
+		buf = append(buf, name...)
+	}
+
+	for _, event := range table.Events {
+		buf = append(buf, byte(event))
+	}
+	for _, time := range table.Times {
+		buf = appendUint64(buf, math.Float64bits(time))
+	}
+	buf = appendInt32s(buf, table.From)
+	buf = appendInt32s(buf, table.To)
+	for _, index := range table.Types {
+		buf = appendUint16(buf, index)
+	}
+	buf = appendInt32s(buf, table.Sizes)
+	for _, flags := range table.Flags {
+		buf = append(buf, byte(flags))
+	}
+	buf = appendInt32s(buf, table.Fids)
+	buf = appendInt32s(buf, table.SrcNodes)
+	buf = appendInt32s(buf, table.SrcPorts)
+	buf = appendInt32s(buf, table.DstNodes)
+	buf = appendInt32s(buf, table.DstPorts)
+	buf = appendInt64s(buf, table.Seqs)
+	buf = appendInt64s(buf, table.Pids)
+
+	// Write the time texts in row order so the file is the same every time
+	rows := make([]int, 0, len(table.TimeText))
+	for row := range table.TimeText {
+		rows = append(rows, row)
+	}
+	sort.Ints(rows)
+	buf = appendUint64(buf, uint64(len(rows)))
+	for _, row := range rows {
+		buf = appendUint64(buf, uint64(row))
+		buf = appendUint16(buf, uint16(len(table.TimeText[row])))
+		buf = append(buf, table.TimeText[row]...)
+	}
+
+	// Write to a temporary file first and rename it into place, so a crash or
+	// another run loading the same trace never sees a half-written cache
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf)
+	if close_err := tmp.Close(); err == nil {
+		err = close_err
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v), byte(v>>8))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v)), uint32(v>>32))
+}
+
+func appendInt32s(buf []byte, column []int32) []byte {
+	for _, v := range column {
+		buf = appendUint32(buf, uint32(v))
+	}
+	return buf
+}
+
+func appendInt64s(buf []byte, column []int64) []byte {
+	for _, v := range column {
+		buf = appendUint64(buf, uint64(v))
+	}
+	return buf
+}
+
+// Read a trace cache file in bulk
+func ReadTraceCache(file string) (*TraceTable, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	if len(data) < 18 || string(data[:4]) != traceCacheMagic {
+		return nil, fmt.Errorf("%s: %w", file, errTraceCache)
+	}
+	if version := le.Uint16(data[4:6]); version != traceCacheVersion {
+		return nil, fmt.Errorf("%s: unsupported trace cache version %d", file, version)
+	}
+	count := le.Uint64(data[8:16])
+	ntypes := int(le.Uint16(data[16:18]))
+	data = data[18:]
+
+	table := &TraceTable{}
+	for i := 0; i < ntypes; i++ {
+		if len(data) < 2 || len(data) < 2+int(le.Uint16(data)) {
+			return nil, fmt.Errorf("%s: truncated trace cache", file)
+		}
+		length := int(le.Uint16(data))
+		table.TypeName = append(table.TypeName, PacketType(data[2:2+length]))
+		data = data[2+length:]
+	}
+	if count > uint64(len(data))/traceCacheRowSize || uint64(len(data)) < count*traceCacheRowSize+8 {
+		return nil, fmt.Errorf("%s: truncated trace cache", file)
+	}
+
+	n := int(count)
+	table.Events = make([]Event, n)
+	for i := range table.Events {
+		table.Events[i] = Event(data[i])
+	}
+	data = data[n:]
+	table.Times = make([]float64, n)
+	for i := range table.Times {
+		table.Times[i] = math.Float64frombits(le.Uint64(data[i*8:]))
+	}
+	data = data[n*8:]
+	table.From, data = readInt32s(data, n)
+	table.To, data = readInt32s(data, n)
+	table.Types = make([]uint16, n)
+	for i := range table.Types {
+		table.Types[i] = le.Uint16(data[i*2:])
+	}
+	data = data[n*2:]
+	table.Sizes, data = readInt32s(data, n)
+	table.Flags = make([]Flags, n)
+	for i := range table.Flags {
+		table.Flags[i] = Flags(data[i])
+	}
+	data = data[n:]
+	table.Fids, data = readInt32s(data, n)
+	table.SrcNodes, data = readInt32s(data, n)
+	table.SrcPorts, data = readInt32s(data, n)
+	table.DstNodes, data = readInt32s(data, n)
+	table.DstPorts, data = readInt32s(data, n)
+	table.Seqs, data = readInt64s(data, n)
+	table.Pids, data = readInt64s(data, n)
+
+	ntexts := le.Uint64(data)
+	data = data[8:]
+	if ntexts > 0 {
+		table.TimeText = make(map[int]string)
+	}
+	for i := uint64(0); i < ntexts; i++ {
+		if len(data) < 10 || len(data) < 10+int(le.Uint16(data[8:])) {
+			return nil, fmt.Errorf("%s: truncated trace cache", file)
+		}
+		row := le.Uint64(data)
+		length := int(le.Uint16(data[8:]))
+		if row >= count {
+			return nil, fmt.Errorf("%s: time text row %d out of range", file, row)
+		}
+		table.TimeText[int(row)] = string(data[10 : 10+length])
+		data = data[10+length:]
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%s: trailing bytes after trace cache", file)
+	}
+
+	for _, index := range table.Types {
+		if int(index) >= len(table.TypeName) {
+			return nil, fmt.Errorf("%s: packet type index %d out of range", file, index)
+		}
+	}
+	return table, nil
+}
+
+// Read a column of n int32 values and return the rest of the data
+func readInt32s(data []byte, n int) ([]int32, []byte) {
+	column := make([]int32, n)
+	for i := range column {
+		column[i] = int32(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return column, data[n*4:]
+}
+
+// Read a column of n int64 values and return the rest of the data
+func readInt64s(data []byte, n int) ([]int64, []byte) {
+	column := make([]int64, n)
+	for i := range column {
+		column[i] = int64(binary.LittleEndian.Uint64(data[i*8:]))
+	}
+	return column, data[n*8:]
+}
+
+// Load a trace file as a TraceTable. The first load parses the trace and
+// writes a cache next to it as <file>.cache, and later loads read the cache
+// as long as it is newer than the trace. The cache is only an optimization,
+// so if it cannot be written the parsed table is still returned
+func LoadTraceTable(file string) (*TraceTable, error) {
+	cache := file + ".cache"
+	if trace_info, err := os.Stat(file); err == nil {
+		if cache_info, err := os.Stat(cache); err == nil && !cache_info.ModTime().Before(trace_info.ModTime()) {
+			if table, err := ReadTraceCache(cache); err == nil {
+				return table, nil
+			}
+		}
+	}
+
+	reader, err := OpenTraceFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	table := &TraceTable{}
+	for reader.Next() {
+		if err := table.Append(reader.Trace()); err != nil {
+			return nil, err
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+	WriteTraceCache(cache, table) // A read-only directory just means the next load parses again
+	return table, nil
+}
+
+// TraceFile is a trace opened from a file, either parsed as it is read or
+// loaded through its cache
+type TraceFile interface {
+	TraceSource
+	Skipped() int // Get the number of malformed lines skipped in Lenient mode
+	Close() error // Close the underlying file
+}
+
+// Open the trace 'file' for streaming. With 'use_cache' it is loaded through
+// <file>.cache as in LoadTraceTable. The cache is always parsed strictly so it
+// never hides skipped lines, which is why it cannot be combined with
+// 'lenient'. The caller must Close the source when done
+func OpenTraceSource(file string, use_cache bool, lenient bool) (TraceFile, error) {
+	if use_cache {
+		if lenient {
+			return nil, errCacheLenient
+		}
+		table, err := LoadTraceTable(file)
+		if err != nil {
+			return nil, err
+		}
+		return table.Reader(), nil
+	}
+	reader, err := OpenTraceFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if lenient {
+		reader.SetMode(Lenient)
+	}
+	return reader, nil
+}
+
+// Parse the trace file through its cache and return a slice of Trace structs
+func ParseTraceFileCached(file string) ([]*Trace, error) {
+	table, err := LoadTraceTable(file)
+	if err != nil {
+		return nil, err
+	}
+	return table.Traces(), nil
+}
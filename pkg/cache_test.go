@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestTraceCacheKeepsTimeText(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.tr")
+	out := filepath.Join(dir, "out.tr")
+	if err := os.WriteFile(in, []byte(roundTripTrace), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first load writes the cache and the second one reads it
+	for i := 0; i < 2; i++ {
+		traces, err := ParseTraceFileCached(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(in + ".cache"); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteTraceFile(out, traces); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, []byte(roundTripTrace)) {
+			t.Errorf("load %d changed the trace\ngot:\n%s\nwant:\n%s", i+1, got, roundTripTrace)
+		}
+	}
+}
+
+func TestTraceCacheManyTypes(t *testing.T) {
+	var traces []*Trace
+	for i := 0; i < 300; i++ {
+		traces = append(traces, NewTrace(Receive, float64(i), 0, 1, PacketType("type"+strconv.Itoa(i)), 40, 1, i, i))
+	}
+	table, err := NewTraceTable(traces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "types.cache")
+	if err := WriteTraceCache(file, table); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadTraceCache(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, trace := range read.Traces() {
+		if trace.String() != traces[i].String() {
+			t.Errorf("row %d = %q, want %q", i, trace, traces[i])
+		}
+	}
+}
+
+func TestOpenTraceSourceCached(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.tr")
+	if err := os.WriteFile(in, []byte(roundTripTrace), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenTraceSource(in, true, true); err != errCacheLenient {
+		t.Errorf("cached and lenient: err = %v, want %v", err, errCacheLenient)
+	}
+
+	var want []string
+	if err := ScanTraceFile(in, func(trace *Trace) { want = append(want, trace.String()) }); err != nil {
+		t.Fatal(err)
+	}
+	source, err := OpenTraceSource(in, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	var got []string
+	if err := ScanTraces(source, func(trace *Trace) { got = append(got, trace.String()) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("read %d traces through the cache, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("trace %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	// The cache is renamed into place, so no temporary file is left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		names := make([]string, len(entries))
+		for i, entry := range entries {
+			names[i] = entry.Name()
+		}
+		t.Errorf("directory holds %v, want only the trace and its cache", names)
+	}
+}
@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+)
+
+// Magic bytes at the start of a compressed file
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Report whether the data starts with the magic bytes of a compression format
+func isCompressed(magic []byte) bool {
+	for _, m := range [][]byte{gzipMagic, bzip2Magic, xzMagic, zstdMagic} {
+		if bytes.HasPrefix(magic, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// A file opened through a decompressor. Closing it closes both
+type decompressedFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *decompressedFile) Close() error {
+	var err error
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		if cerr := f.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Open a file for reading and decompress it on the fly if it starts with the
+// magic bytes of gzip or bzip2. xz and zstd files are detected but not
+// supported, so they return an error. Plain files are read as they are
+func openDecompressed(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(f)
+	magic, _ := buffered.Peek(6)
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &decompressedFile{Reader: gz, closers: []io.Closer{f, gz}}, nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return &decompressedFile{Reader: bzip2.NewReader(buffered), closers: []io.Closer{f}}, nil
+	case bytes.HasPrefix(magic, xzMagic):
+		f.Close()
+		return nil, errors.New(file + ": xz compression is not supported, decompress it first")
+	case bytes.HasPrefix(magic, zstdMagic):
+		f.Close()
+		return nil, errors.New(file + ": zstd compression is not supported, decompress it first")
+	}
+	return &decompressedFile{Reader: buffered, closers: []io.Closer{f}}, nil
+}
+
+// Compress the file 'src' with gzip into the new file 'dst'
+func CompressFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		gz.Close()
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A two line trace and the same trace compressed with bzip2 -9, which the
+// standard library can read but not write
+const bzip2Trace = "+ 0.1 0 1 tcp 1040 ------- 1 0.0 3.0 0 0\nr 0.2 0 1 tcp 1040 ------- 1 0.0 3.0 0 0\n"
+
+var bzip2TraceData = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x5f, 0xfd, 0xde, 0x30, 0x00, 0x00,
+	0x0d, 0x59, 0x80, 0x08, 0x10, 0x40, 0x0b, 0x7c, 0x00, 0x08, 0x00, 0x54, 0x00, 0x20, 0x00, 0x50,
+	0xa6, 0x00, 0x00, 0x2a, 0xa6, 0x93, 0x0d, 0x23, 0x12, 0xcc, 0x09, 0x9a, 0x67, 0x98, 0x5d, 0xc4,
+	0x2e, 0x99, 0x53, 0x4f, 0x97, 0x2a, 0xda, 0x1b, 0x42, 0x10, 0x65, 0x5a, 0xa9, 0x10, 0xb3, 0xae,
+	0xb5, 0x67, 0xe2, 0xee, 0x48, 0xa7, 0x0a, 0x12, 0x0b, 0xff, 0xbb, 0xc6, 0x00,
+}
+
+// Read every trace of a file and print it back as trace lines
+func readTraceText(t *testing.T, file string) string {
+	t.Helper()
+	traces, err := ParseTraceFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	for _, trace := range traces {
+		out.WriteString(trace.String() + "\n")
+	}
+	return out.String()
+}
+
+func TestIsCompressed(t *testing.T) {
+	tests := []struct {
+		magic []byte
+		want  bool
+	}{
+		{gzipMagic, true},
+		{bzip2TraceData[:6], true},
+		{xzMagic, true},
+		{zstdMagic, true},
+		{[]byte("r 0.1 "), false},
+		{[]byte("BZ"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if got := isCompressed(test.magic); got != test.want {
+			t.Errorf("isCompressed(%q) = %v, want %v", test.magic, got, test.want)
+		}
+	}
+}
+
+func TestCompressFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "out.tr")
+	compressed := filepath.Join(dir, "out.tr.gz")
+	if err := os.WriteFile(plain, []byte(sharedPathTrace()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompressFile(plain, compressed); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), string(gzipMagic)) {
+		t.Fatalf("%s does not start with the gzip magic", compressed)
+	}
+	want := readTraceText(t, plain)
+	if got := readTraceText(t, compressed); got != want {
+		t.Errorf("gzip round trip changed the traces\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReadBzip2Trace(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "out.tr.bz2")
+	if err := os.WriteFile(file, bzip2TraceData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTraceText(t, file); got != bzip2Trace {
+		t.Errorf("got\n%s\nwant\n%s", got, bzip2Trace)
+	}
+}
+
+func TestUnsupportedCompression(t *testing.T) {
+	dir := t.TempDir()
+	for name, magic := range map[string][]byte{"xz": xzMagic, "zstd": zstdMagic} {
+		file := filepath.Join(dir, "out.tr."+name)
+		if err := os.WriteFile(file, append(magic, "not really compressed"...), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ParseTraceFile(file)
+		want := file + ": " + name + " compression is not supported, decompress it first"
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: err = %v, want %q", name, err, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import "math"
+
+// Get Jain's fairness index of the flows' throughputs, from 1/n when a single
+// flow gets everything to 1 when every flow gets the same. NaN if there are
+// no flows or none of them sent anything
+func JainIndex(throughputs []float64) float64 {
+	var sum, sum_squares float64
+	for _, x := range throughputs {
+		sum += x
+		sum_squares += x * x
+	}
+	if sum_squares == 0 {
+		return math.NaN()
+	}
+	return sum * sum / (float64(len(throughputs)) * sum_squares)
+}
+
+// Get the ratio of the largest to the smallest throughput, which is 1 when
+// every flow gets the same. +Inf if a flow got nothing, NaN if there are no flows
+func MaxMinRatio(throughputs []float64) float64 {
+	if len(throughputs) == 0 {
+		return math.NaN()
+	}
+	return Max(throughputs) / Min(throughputs)
+}
+
+// Get each flow's share of the total throughput, from 0 to 1. All NaN if
+// none of the flows sent anything
+func Shares(throughputs []float64) []float64 {
+	total := Sum(throughputs)
+	shares := make([]float64, len(throughputs))
+	for i, x := range throughputs {
+		if total == 0 {
+			shares[i] = math.NaN()
+		} else {
+			shares[i] = x / total
+		}
+	}
+	return shares
+}
+
+// FairnessMeter measures Jain's fairness index between several flows over a
+// sliding window of 'size' seconds in a single pass over a trace. It emits a
+// tick every time one of the flows receives a packet over the link. Give it a
+// span where every flow is running, otherwise a flow that has not started
+// yet counts as starved
+type FairnessMeter struct {
+	link  Link
+	span  TimeSpan
+	size  float64
+	flows map[int]int // A hashmap with {key, value} of {fid, index into flow_bytes}
+
+	win_times  []float64 // Receive times of the packets in the current window
+	win_flows  []int     // Flow indexes of the packets in the current window, aligned with win_times
+	win_sizes  []int     // Sizes of the packets in the current window, aligned with win_times
+	flow_bytes []float64 // The number of bytes each flow received in the current window
+
+	time_ticks     []float64
+	fairness_ticks []float64
+}
+
+// Create a FairnessMeter between the flows 'fids' over the link 'from_node' ->
+// 'to_node' with a sliding window of 'window_size' seconds that only measures inside 'span'
+func NewFairnessMeter(from_node int, to_node int, span TimeSpan, window_size float64, fids ...int) *FairnessMeter {
+	flows := make(map[int]int, len(fids))
+	for i, fid := range fids {
+		flows[fid] = i
+	}
+	return &FairnessMeter{
+		link:       Link{From: from_node, To: to_node},
+		span:       span,
+		size:       window_size,
+		flows:      flows,
+		flow_bytes: make([]float64, len(fids)),
+	}
+}
+
+// Add the next trace. Traces must arrive in time order
+func (m *FairnessMeter) Add(trace *Trace) {
+	if trace.Event != Receive || trace.From != m.link.From || trace.To != m.link.To || !m.span.Contains(trace.Time) {
+		return
+	}
+	flow, ok := m.flows[trace.Fid]
+	if !ok {
+		return
+	}
+	// Expire every packet that left the window by the time this one arrived, the same as SlidingWindow
+	for len(m.win_times) > 0 && leftWindow(m.win_times[0], trace.Time, m.size) {
+		m.flow_bytes[m.win_flows[0]] -= float64(m.win_sizes[0])
+		m.win_times = m.win_times[1:]
+		m.win_flows = m.win_flows[1:]
+		m.win_sizes = m.win_sizes[1:]
+	}
+	m.win_times = append(m.win_times, trace.Time)
+	m.win_flows = append(m.win_flows, flow)
+	m.win_sizes = append(m.win_sizes, trace.Size)
+	m.flow_bytes[flow] += float64(trace.Size)
+
+	// Every flow shares the same window, so the bytes are proportional to the throughputs
+	m.time_ticks = append(m.time_ticks, trace.Time)
+	m.fairness_ticks = append(m.fairness_ticks, JainIndex(m.flow_bytes))
+}
+
+// Return slice times, slice Jain's fairness indexes, and average fairness index
+func (m *FairnessMeter) Result() ([]float64, []float64, float64) {
+	return m.time_ticks, m.fairness_ticks, Mean(m.fairness_ticks)
+}
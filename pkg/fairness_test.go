@@ -0,0 +1,46 @@
+package pkg
+
+import (
+	"math"
+	"testing"
+)
+
+func TestJainIndex(t *testing.T) {
+	tests := []struct {
+		throughputs []float64
+		want        float64
+	}{
+		{[]float64{5, 5}, 1},
+		{[]float64{2.5, 2.5, 2.5, 2.5}, 1},
+		{[]float64{7}, 1},
+		{[]float64{10, 0}, 0.5},
+		{[]float64{0, 0, 9, 0}, 0.25},
+		{[]float64{3, 1}, 0.8}, // 16 / (2 * 10)
+	}
+	for _, test := range tests {
+		if got := JainIndex(test.throughputs); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("JainIndex(%v) = %g, want %g", test.throughputs, got, test.want)
+		}
+	}
+	for _, throughputs := range [][]float64{nil, {0, 0}} {
+		if got := JainIndex(throughputs); !math.IsNaN(got) {
+			t.Errorf("JainIndex(%v) = %g, want NaN", throughputs, got)
+		}
+	}
+}
+
+func TestSharesAndMaxMinRatio(t *testing.T) {
+	shares := Shares([]float64{3, 1})
+	if len(shares) != 2 || shares[0] != 0.75 || shares[1] != 0.25 {
+		t.Errorf("Shares(3, 1) = %v, want [0.75 0.25]", shares)
+	}
+	if shares := Shares([]float64{0, 0}); !math.IsNaN(shares[0]) || !math.IsNaN(shares[1]) {
+		t.Errorf("Shares(0, 0) = %v, want NaN for both", shares)
+	}
+	if got := MaxMinRatio([]float64{3, 1}); got != 3 {
+		t.Errorf("MaxMinRatio(3, 1) = %g, want 3", got)
+	}
+	if got := MaxMinRatio([]float64{3, 0}); !math.IsInf(got, 1) {
+		t.Errorf("MaxMinRatio(3, 0) = %g, want +Inf", got)
+	}
+}
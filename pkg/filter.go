@@ -0,0 +1,385 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Keep only traces that pass every filter
+func And(filters ...TraceFilter) TraceFilter {
+	return func(trace *Trace) bool {
+		for _, filter := range filters {
+			if !filter(trace) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Keep only traces that pass at least one filter
+func Or(filters ...TraceFilter) TraceFilter {
+	return func(trace *Trace) bool {
+		for _, filter := range filters {
+			if filter(trace) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Keep only traces that do not pass the filter
+func Not(filter TraceFilter) TraceFilter {
+	return func(trace *Trace) bool {
+		return !filter(trace)
+	}
+}
+
+// Keep only traces of event 'event'
+func EventFilter(event Event) TraceFilter {
+	return func(trace *Trace) bool {
+		return trace.Event == event
+	}
+}
+
+// Keep only traces on the link 'from' -> 'to'
+func LinkFilter(from int, to int) TraceFilter {
+	return func(trace *Trace) bool {
+		return trace.From == from && trace.To == to
+	}
+}
+
+// Keep only traces with start <= time < end
+func TimeFilter(start float64, end float64) TraceFilter {
+	return func(trace *Trace) bool {
+		return trace.Time >= start && trace.Time < end
+	}
+}
+
+// Get a slice of the traces that pass the filter
+func FilterTraces(traces []*Trace, filter TraceFilter) []*Trace {
+	var filtered []*Trace
+	for _, trace := range traces {
+		if filter(trace) {
+			filtered = append(filtered, trace)
+		}
+	}
+	return filtered
+}
+
+// The numeric fields a filter expression can compare
+var numericFields = map[string]func(*Trace) float64{
+	"time":  func(t *Trace) float64 { return t.Time },
+	"from":  func(t *Trace) float64 { return float64(t.From) },
+	"to":    func(t *Trace) float64 { return float64(t.To) },
+	"size":  func(t *Trace) float64 { return float64(t.Size) },
+	"fid":   func(t *Trace) float64 { return float64(t.Fid) },
+	"src":   func(t *Trace) float64 { return float64(t.Src.Node) },
+	"dst":   func(t *Trace) float64 { return float64(t.Dst.Node) },
+	"sport": func(t *Trace) float64 { return float64(t.Src.Port) },
+	"dport": func(t *Trace) float64 { return float64(t.Dst.Port) },
+	"seq":   func(t *Trace) float64 { return float64(t.Seq) },
+	"pid":   func(t *Trace) float64 { return float64(t.Pid) },
+}
+
+// Event names a filter expression accepts besides the ns2 symbols
+var eventNames = map[string]Event{
+	"enqueue": Enqueue,
+	"dequeue": Dequeue,
+	"receive": Receive,
+	"drop":    Drop,
+}
+
+// Parse a textual filter expression into a TraceFilter, for example
+//
+//	event==r && link==1->2 && fid in {1,2} && time>=5
+//
+// Comparisons are 'field op value' with op one of == != < <= > >=, or
+// 'field in {v1, v2, ...}'. Numeric fields are time, from, to, size, fid,
+// src, dst (source and destination nodes), sport, dport, seq and pid.
+// 'event' takes + - r d (or enqueue, dequeue, receive, drop), 'type' takes a
+// packet type name and 'link' takes from->to. Comparisons combine with
+// && || ! and parentheses
+func ParseFilter(expr string) (TraceFilter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().text != "" {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+	return filter, nil
+}
+
+// A token of a filter expression and its byte offset
+type filterToken struct {
+	text string
+	pos  int
+}
+
+// Split a filter expression into tokens. The final token is always empty
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+			continue
+		case isFilterWordByte(c):
+			for i < len(expr) && (isFilterWordByte(expr[i]) || expr[i] == '.') {
+				i++
+			}
+			// Keep exponents such as 1e-05 in a single number token
+			if c >= '0' && c <= '9' && i < len(expr) && (expr[i] == '-' || expr[i] == '+') &&
+				(expr[i-1] == 'e' || expr[i-1] == 'E') {
+				i++
+				for i < len(expr) && expr[i] >= '0' && expr[i] <= '9' {
+					i++
+				}
+			}
+		case c == '"':
+			end := strings.IndexByte(expr[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("filter: position %d: unterminated string", i)
+			}
+			i += end + 2
+		default:
+			for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||", "->", "<", ">", "!", "(", ")", "{", "}", ",", "+", "-"} {
+				if strings.HasPrefix(expr[i:], op) {
+					i += len(op)
+					break
+				}
+			}
+			if i == start {
+				return nil, fmt.Errorf("filter: position %d: unexpected %q", i, c)
+			}
+		}
+		tokens = append(tokens, filterToken{text: expr[start:i], pos: start})
+	}
+	return append(tokens, filterToken{pos: len(expr)}), nil
+}
+
+func isFilterWordByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// A recursive descent parser over the tokens of a filter expression
+type filterParser struct {
+	tokens []filterToken
+	next   int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.next]
+}
+
+func (p *filterParser) take() filterToken {
+	token := p.tokens[p.next]
+	if token.text != "" {
+		p.next++
+	}
+	return token
+}
+
+func (p *filterParser) expect(text string) error {
+	if token := p.take(); token.text != text {
+		return p.errorAt(token, "expected %q", text)
+	}
+	return nil
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return p.errorAt(p.peek(), format, args...)
+}
+
+func (p *filterParser) errorAt(token filterToken, format string, args ...interface{}) error {
+	return fmt.Errorf("filter: position %d: %s", token.pos, fmt.Sprintf(format, args...))
+}
+
+// or := and ("||" and)*
+func (p *filterParser) parseOr() (TraceFilter, error) {
+	filter, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	filters := []TraceFilter{filter}
+	for p.peek().text == "||" {
+		p.take()
+		if filter, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+	return Or(filters...), nil
+}
+
+// and := unary ("&&" unary)*
+func (p *filterParser) parseAnd() (TraceFilter, error) {
+	filter, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	filters := []TraceFilter{filter}
+	for p.peek().text == "&&" {
+		p.take()
+		if filter, err = p.parseUnary(); err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+	return And(filters...), nil
+}
+
+// unary := "!" unary | "(" or ")" | comparison
+func (p *filterParser) parseUnary() (TraceFilter, error) {
+	switch p.peek().text {
+	case "!":
+		p.take()
+		filter, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(filter), nil
+	case "(":
+		p.take()
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return filter, p.expect(")")
+	}
+	return p.parseComparison()
+}
+
+// comparison := field op value | field "in" "{" value ("," value)* "}"
+func (p *filterParser) parseComparison() (TraceFilter, error) {
+	field := p.take()
+	if field.text == "" {
+		return nil, p.errorAt(field, "expected a comparison")
+	}
+
+	op := p.take()
+	if op.text == "in" {
+		if err := p.expect("{"); err != nil {
+			return nil, err
+		}
+		var filters []TraceFilter
+		for {
+			filter, err := p.parseValue(field, "==")
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+			if p.peek().text != "," {
+				break
+			}
+			p.take()
+		}
+		return Or(filters...), p.expect("}")
+	}
+	switch op.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return p.parseValue(field, op.text)
+	}
+	return nil, p.errorAt(op, "expected a comparison operator after %q", field.text)
+}
+
+// Parse the value of a comparison of 'field' and build its filter
+func (p *filterParser) parseValue(field filterToken, op string) (TraceFilter, error) {
+	switch field.text {
+	case "event":
+		token := p.take()
+		event, ok := eventNames[strings.Trim(token.text, `"`)]
+		if !ok {
+			var err error
+			if event, err = ParseEvent(strings.Trim(token.text, `"`)); err != nil {
+				return nil, p.errorAt(token, "%v", err)
+			}
+		}
+		return equalityFilter(p, field, op, EventFilter(event))
+	case "type":
+		token := p.take()
+		if token.text == "" {
+			return nil, p.errorAt(token, "expected a packet type")
+		}
+		return equalityFilter(p, field, op, TypeFilter(PacketType(strings.Trim(token.text, `"`))))
+	case "link":
+		from, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("->"); err != nil {
+			return nil, err
+		}
+		to, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		return equalityFilter(p, field, op, LinkFilter(int(from), int(to)))
+	}
+
+	get, ok := numericFields[field.text]
+	if !ok {
+		return nil, p.errorAt(field, "unknown field %q", field.text)
+	}
+	value, err := p.parseNumber()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "==":
+		return func(t *Trace) bool { return get(t) == value }, nil
+	case "!=":
+		return func(t *Trace) bool { return get(t) != value }, nil
+	case "<":
+		return func(t *Trace) bool { return get(t) < value }, nil
+	case "<=":
+		return func(t *Trace) bool { return get(t) <= value }, nil
+	case ">":
+		return func(t *Trace) bool { return get(t) > value }, nil
+	default:
+		return func(t *Trace) bool { return get(t) >= value }, nil
+	}
+}
+
+// Build the filter of a field that only supports == and !=
+func equalityFilter(p *filterParser, field filterToken, op string, filter TraceFilter) (TraceFilter, error) {
+	switch op {
+	case "==":
+		return filter, nil
+	case "!=":
+		return Not(filter), nil
+	}
+	return nil, p.errorAt(field, "%q only supports == and !=", field.text)
+}
+
+// Parse an optionally negative number
+func (p *filterParser) parseNumber() (float64, error) {
+	sign := 1.0
+	if p.peek().text == "-" {
+		p.take()
+		sign = -1
+	}
+	token := p.take()
+	value, err := strconv.ParseFloat(token.text, 64)
+	if err != nil {
+		return 0, p.errorAt(token, "expected a number, got %q", token.text)
+	}
+	return sign * value, nil
+}
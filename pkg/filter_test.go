@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const filterTrace = `+ 0.5 1 2 tcp 1040 ------- 1 0.0 3.0 0 0
+r 0.00001 1 2 tcp 1040 ------- 1 0.0 3.0 1 1
+d 5 2 3 cbr 1000 ------- 2 1.0 2.0 -1 2
+- 7.5 2 1 ack 40 ------- 1 3.0 0.0 3 3
+`
+
+func TestParseFilter(t *testing.T) {
+	traces := parseTestTraces(t, filterTrace)
+	tests := []struct {
+		expr string
+		want []int // Indexes of the matching traces
+	}{
+		{"event==r", []int{1}},
+		{`event==receive || event=="d"`, []int{1, 2}},
+		{"event==-", []int{3}},
+		{"event!=r", []int{0, 2, 3}},
+		{"type!=tcp", []int{2, 3}},
+		{`type=="ack"`, []int{3}},
+		{"link==1->2", []int{0, 1}},
+		{"link!=1->2", []int{2, 3}},
+		{"link==2->1", []int{3}},
+		{"time<1e-04", []int{1}},
+		{"time<=1.5e-5", []int{1}},
+		{"time>=5E+0", []int{2, 3}},
+		{"seq==-1", []int{2}},
+		{"seq>-1&&seq<3", []int{0, 1}},
+		{"fid in {1, 2}", []int{0, 1, 2, 3}},
+		{"fid in {2}", []int{2}},
+		{"!fid==1", []int{2}},
+		{"!(fid==1) || pid==0", []int{0, 2}},
+		{"size>=1000 && (event==d || event==-)", []int{2}},
+		{"src==1 || dst==0 && sport==0", []int{2, 3}},
+	}
+	for _, test := range tests {
+		filter, err := ParseFilter(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		var got []int
+		for i, trace := range traces {
+			if filter(trace) {
+				got = append(got, i)
+			}
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%q matched %v, want %v", test.expr, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%q matched %v, want %v", test.expr, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int // The byte offset the error points at
+	}{
+		{"", 0},
+		{"fid==1 &&", 9},
+		{"fid in {}", 8},
+		{"event in {}", 10},
+		{"type<tcp", 0},
+		{"link>1->2", 0},
+		{"event>=r", 0},
+		{"event==x", 7},
+		{"fid==1 2", 7},
+		{"fid==1)", 6},
+		{"(fid==1", 7},
+		{"colour==1", 0},
+		{"fid=1", 3},
+		{"fid ~ 1", 4},
+		{"fid==", 5},
+		{"fid 1", 4},
+		{"time<1e-", 5},
+		{"link==1-2", 7},
+		{`type=="tcp`, 6},
+	}
+	for _, test := range tests {
+		_, err := ParseFilter(test.expr)
+		if err == nil {
+			t.Errorf("%q: expected an error", test.expr)
+			continue
+		}
+		want := "filter: position " + strconv.Itoa(test.pos) + ":"
+		if !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%q: err = %q, want it at position %d", test.expr, err, test.pos)
+		}
+	}
+}
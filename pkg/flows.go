@@ -0,0 +1,278 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FlowSummary describes a single flow id of a trace
+type FlowSummary struct {
+	Fid     int
+	Types   []PacketType // Packet types in order of first appearance
+	Src     int          // The node that sends the flow's data
+	Dst     int          // The node that the flow's data is addressed to
+	First   float64      // Time of the first trace of the flow
+	Last    float64      // Time of the last trace of the flow
+	Links   []Link       // Links in order of first traversal
+	Packets int          // The number of distinct packets
+	Bytes   int          // The total size of the distinct packets
+	Drops   int          // The number of drop events
+
+	has_data bool // Src and Dst come from a data packet rather than an ack
+}
+
+// Check if the flow traverses the link 'from' -> 'to'
+func (f *FlowSummary) Traverses(from int, to int) bool {
+	for _, link := range f.Links {
+		if link.From == from && link.To == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if the flow carries packets of type 'packet_type'
+func (f *FlowSummary) HasType(packet_type PacketType) bool {
+	for _, t := range f.Types {
+		if t == packet_type {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the type of the flow's data packets, the first type that is not an ack
+func (f *FlowSummary) DataType() PacketType {
+	for _, t := range f.Types {
+		if t != Ack {
+			return t
+		}
+	}
+	return Ack
+}
+
+// Check if the flow's data is acknowledged, as in TCP
+func (f *FlowSummary) Acked() bool {
+	return f.HasType(Ack)
+}
+
+// Split 'flows' into the ones whose data is acknowledged, such as TCP, and
+// the ones whose data is not, such as CBR. Both keep the order of 'flows'
+func SplitAckedFlows(flows []*FlowSummary) ([]*FlowSummary, []*FlowSummary) {
+	var acked, unacked []*FlowSummary
+	for _, flow := range flows {
+		if flow.Acked() {
+			acked = append(acked, flow)
+		} else {
+			unacked = append(unacked, flow)
+		}
+	}
+	return acked, unacked
+}
+
+// FlowDiscovery enumerates the flows of a trace in a single pass
+type FlowDiscovery struct {
+	flows    map[int]*FlowSummary
+	pids     map[int]struct{} // Packets that were counted and are still on the way
+	links    map[int]map[Link]struct{}
+	enqueues map[Link]int // The number of enqueue events on each link
+	drops    map[Link]int // The number of drop events on each link
+}
+
+// Create an empty FlowDiscovery
+func NewFlowDiscovery() *FlowDiscovery {
+	return &FlowDiscovery{
+		flows:    make(map[int]*FlowSummary),
+		pids:     make(map[int]struct{}),
+		links:    make(map[int]map[Link]struct{}),
+		enqueues: make(map[Link]int),
+		drops:    make(map[Link]int),
+	}
+}
+
+// Add the next trace
+func (d *FlowDiscovery) Add(trace *Trace) {
+	flow, ok := d.flows[trace.Fid]
+	if !ok {
+		flow = &FlowSummary{Fid: trace.Fid, Src: trace.Src.Node, Dst: trace.Dst.Node, First: trace.Time, Last: trace.Time}
+		d.flows[trace.Fid] = flow
+		d.links[trace.Fid] = make(map[Link]struct{})
+	}
+	// The first packet of a TCP flow is data, but a trace may be cut so that an ack comes first
+	if !flow.has_data && trace.Type != Ack {
+		flow.Src = trace.Src.Node
+		flow.Dst = trace.Dst.Node
+		flow.has_data = true
+	}
+	if trace.Time < flow.First {
+		flow.First = trace.Time
+	}
+	if trace.Time > flow.Last {
+		flow.Last = trace.Time
+	}
+	if !flow.HasType(trace.Type) {
+		flow.Types = append(flow.Types, trace.Type)
+	}
+	link := Link{From: trace.From, To: trace.To}
+	if _, ok := d.links[trace.Fid][link]; !ok {
+		d.links[trace.Fid][link] = struct{}{}
+		flow.Links = append(flow.Links, link)
+	}
+	if _, ok := d.pids[trace.Pid]; !ok {
+		d.pids[trace.Pid] = struct{}{}
+		flow.Packets++
+		flow.Bytes += trace.Size
+	}
+	switch trace.Event {
+	case Enqueue:
+		d.enqueues[link]++
+	case Drop:
+		flow.Drops++
+		d.drops[link]++
+		delete(d.pids, trace.Pid) // A dropped packet has no more traces
+	case Receive:
+		if trace.To == trace.Dst.Node {
+			delete(d.pids, trace.Pid) // Neither does a delivered one
+		}
+	}
+}
+
+// Return every flow sorted by fid
+func (d *FlowDiscovery) Result() []*FlowSummary {
+	flows := make([]*FlowSummary, 0, len(d.flows))
+	for _, flow := range d.flows {
+		flows = append(flows, flow)
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		return flows[i].Fid < flows[j].Fid
+	})
+	return flows
+}
+
+// Enumerate every flow of a trace and return them sorted by fid
+func DiscoverFlows(traces []*Trace) []*FlowSummary {
+	discovery := NewFlowDiscovery()
+	for _, trace := range traces {
+		discovery.Add(trace)
+	}
+	return discovery.Result()
+}
+
+// Get the bottleneck among the links that every one of 'flows' traverses,
+// the one that dropped the most packets and then the one that queued the
+// most. Every link on a path enqueues about the same packets, so the drops
+// decide. Return false if the flows share no link
+func (d *FlowDiscovery) Bottleneck(flows []*FlowSummary) (Link, bool) {
+	shared := SharedLinks(flows)
+	if len(shared) == 0 {
+		return Link{}, false
+	}
+	bottleneck := shared[0]
+	for _, link := range shared[1:] {
+		if d.drops[link] > d.drops[bottleneck] ||
+			(d.drops[link] == d.drops[bottleneck] && d.enqueues[link] > d.enqueues[bottleneck]) {
+			bottleneck = link
+		}
+	}
+	return bottleneck, true
+}
+
+var errNoSharedLink = errors.New("the flows share no link")
+
+// Enumerate every flow of 'src' and find the bottleneck link they all share.
+// With a topology the bottleneck is its queue-limited link, which must be
+// shared by every flow, otherwise it is picked from the trace as in
+// FlowDiscovery.Bottleneck. Return the flows sorted by fid and the bottleneck
+func DiscoverBottleneck(src TraceSource, topology *Ns2Topology) ([]*FlowSummary, Link, error) {
+	discovery := NewFlowDiscovery()
+	if err := ScanTraces(src, discovery.Add); err != nil {
+		return nil, Link{}, err
+	}
+	flows := discovery.Result()
+	bottleneck, ok := discovery.Bottleneck(flows)
+	if !ok {
+		return nil, Link{}, errNoSharedLink
+	}
+	if topology != nil {
+		bottleneck, ok = topology.Bottleneck()
+		if !ok {
+			return nil, Link{}, errors.New("the topology sets no queue limit")
+		}
+		for _, flow := range flows {
+			if !flow.Traverses(bottleneck.From, bottleneck.To) {
+				return nil, Link{}, fmt.Errorf("flow %d does not traverse the bottleneck %s",
+					flow.Fid, topology.LinkName(bottleneck))
+			}
+		}
+	}
+	return flows, bottleneck, nil
+}
+
+// Enumerate every flow of the trace 'file' and find their bottleneck as in
+// DiscoverBottleneck. Malformed lines are skipped, so a trace the experiments
+// can measure can also be discovered
+func DiscoverTraceFile(file string, topology *Ns2Topology) ([]*FlowSummary, Link, error) {
+	source, err := OpenTraceSource(file, false, true)
+	if err != nil {
+		return nil, Link{}, err
+	}
+	defer source.Close()
+	return DiscoverBottleneck(source, topology)
+}
+
+// Get the links that every one of 'flows' traverses, such as a shared bottleneck
+func SharedLinks(flows []*FlowSummary) []Link {
+	if len(flows) == 0 {
+		return nil
+	}
+	var shared []Link
+	for _, link := range flows[0].Links {
+		all := true
+		for _, flow := range flows[1:] {
+			if !flow.Traverses(link.From, link.To) {
+				all = false
+				break
+			}
+		}
+		if all {
+			shared = append(shared, link)
+		}
+	}
+	return shared
+}
+
+// Write the flow summaries as a CSV table with a header row. Types and links
+// are separated by spaces within their column
+func WriteFlowSummaries(w io.Writer, flows []*FlowSummary) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"fid", "types", "src", "dst", "first", "last", "links", "packets", "bytes", "drops"})
+	for _, flow := range flows {
+		types := make([]string, len(flow.Types))
+		for i, t := range flow.Types {
+			types[i] = string(t)
+		}
+		links := make([]string, len(flow.Links))
+		for i, link := range flow.Links {
+			links[i] = strconv.Itoa(link.From) + "->" + strconv.Itoa(link.To)
+		}
+		writer.Write([]string{
+			strconv.Itoa(flow.Fid),
+			strings.Join(types, " "),
+			strconv.Itoa(flow.Src),
+			strconv.Itoa(flow.Dst),
+			strconv.FormatFloat(flow.First, 'f', -1, 64),
+			strconv.FormatFloat(flow.Last, 'f', -1, 64),
+			strings.Join(links, " "),
+			strconv.Itoa(flow.Packets),
+			strconv.Itoa(flow.Bytes),
+			strconv.Itoa(flow.Drops),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Parse hand-written ns2 trace lines, one per line of 'text'
+func parseTestTraces(t *testing.T, text string) []*Trace {
+	t.Helper()
+	var traces []*Trace
+	reader := NewTraceReader(strings.NewReader(text))
+	for reader.Next() {
+		traces = append(traces, reader.Trace())
+	}
+	if err := reader.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return traces
+}
+
+// Get the trace lines of a packet that crosses 'nodes' one hop every 10ms
+// from 'start'. It is dropped when it reaches the queue of hop 'drop_hop',
+// or delivered if 'drop_hop' is -1
+func hopLines(start float64, nodes []int, packet_type string, fid int, pid int, drop_hop int) string {
+	src := strconv.Itoa(nodes[0]) + ".0"
+	dst := strconv.Itoa(nodes[len(nodes)-1]) + ".0"
+	var lines []string
+	line := func(event string, time float64, hop int) {
+		lines = append(lines, strings.Join([]string{event, strconv.FormatFloat(time, 'f', -1, 64),
+			strconv.Itoa(nodes[hop]), strconv.Itoa(nodes[hop+1]), packet_type, "1000", "-------",
+			strconv.Itoa(fid), src, dst, strconv.Itoa(pid), strconv.Itoa(pid)}, " "))
+	}
+	time := start
+	for hop := 0; hop < len(nodes)-1; hop++ {
+		line("+", time, hop)
+		if hop == drop_hop {
+			line("d", time, hop)
+			break
+		}
+		line("-", time, hop)
+		time += 0.01
+		line("r", time, hop)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Two flows from n1 to n4 as in simulation03.tcl, with drops at the N2 -> N3 queue
+func sharedPathTrace() string {
+	path := []int{0, 1, 2, 3}
+	var text string
+	for i := 0; i < 6; i++ {
+		drop_hop := -1
+		if i%3 == 2 {
+			drop_hop = 1
+		}
+		start := float64(i) * 0.1
+		text += hopLines(start, path, "tcp", 1, 2*i, drop_hop)
+		if drop_hop < 0 {
+			text += hopLines(start+0.03, []int{3, 2, 1, 0}, "ack", 1, 100+2*i, -1)
+		}
+		text += hopLines(start+0.05, path, "cbr", 2, 2*i+1, drop_hop)
+	}
+	return text
+}
+
+func TestBottleneckOfSharedPath(t *testing.T) {
+	discovery := NewFlowDiscovery()
+	for _, trace := range parseTestTraces(t, sharedPathTrace()) {
+		discovery.Add(trace)
+	}
+	flows := discovery.Result()
+
+	// Every link of the path is shared, so the first one is not necessarily the bottleneck
+	shared := SharedLinks(flows)
+	want := []Link{{0, 1}, {1, 2}, {2, 3}}
+	if len(shared) != len(want) {
+		t.Fatalf("shared links = %v, want %v", shared, want)
+	}
+	for i := range want {
+		if shared[i] != want[i] {
+			t.Fatalf("shared links = %v, want %v", shared, want)
+		}
+	}
+	bottleneck, ok := discovery.Bottleneck(flows)
+	if !ok || bottleneck != (Link{1, 2}) {
+		t.Errorf("bottleneck = %v, %v, want {1 2}", bottleneck, ok)
+	}
+
+	// Every packet was delivered or dropped, so none of them is remembered
+	if len(discovery.pids) != 0 {
+		t.Errorf("%d pids still remembered, want 0", len(discovery.pids))
+	}
+	if flows[0].Packets != 10 || flows[0].Drops != 2 || flows[1].Packets != 6 || flows[1].Drops != 2 {
+		t.Errorf("flows = %+v and %+v, want 10 packets (6 tcp and 4 acks) and 2 drops, then 6 packets and 2 drops", *flows[0], *flows[1])
+	}
+}
+
+func TestDiscoverBottleneckFromTopology(t *testing.T) {
+	topology, err := ReadNs2Topology("../ns2/simulation03.tcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewTraceReader(strings.NewReader(sharedPathTrace()))
+	flows, bottleneck, err := DiscoverBottleneck(source, topology)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 2 || bottleneck != (Link{1, 2}) {
+		t.Errorf("got %d flows and bottleneck %v, want 2 flows and {1 2}", len(flows), bottleneck)
+	}
+	tcp, cbr := SplitAckedFlows(flows)
+	if len(tcp) != 1 || tcp[0].DataType() != "tcp" || len(cbr) != 1 || cbr[0].DataType() != "cbr" {
+		t.Errorf("got %d acked and %d unacked flows, want one tcp and one cbr", len(tcp), len(cbr))
+	}
+
+	// A flow that does not cross the script's bottleneck is an error, not a silent pick of another link
+	source = NewTraceReader(strings.NewReader(hopLines(0, []int{0, 1}, "cbr", 3, 0, -1)))
+	if _, _, err := DiscoverBottleneck(source, topology); err == nil {
+		t.Error("expected an error for a flow that misses the bottleneck")
+	}
+}
@@ -0,0 +1,100 @@
+package pkg
+
+// A flow identified by its 5-tuple. The two endpoints are stored in a fixed
+// order so that both directions of a connection map to the same flow
+type flowKey struct {
+	protocol int
+	a_ip     string
+	a_port   int
+	b_ip     string
+	b_port   int
+}
+
+// The identity of a single packet as seen on the wire. It stays the same at
+// every capture point, so the same packet gets the same pid everywhere
+type packetKey struct {
+	protocol int
+	src_ip   string
+	src_port int
+	dst_ip   string
+	dst_port int
+	ip_id    int // The IPv4 identification, or -1 for IPv6
+	seq      int
+	ack      int
+	length   int
+	checksum int // The transport checksum tells apart packets with equal headers
+}
+
+// FlowTable numbers flows from 1 in order of first appearance, the way ns2
+// scripts number them with fid_. Data and ACKs of a connection share a fid.
+// Readers that share a FlowTable agree on every fid and pid, so captures
+// taken at several vantage points can be analyzed together.
+// By default every packet ever seen is remembered, so memory grows with the
+// length of the capture. Set a pid expiry to stream long captures
+type FlowTable struct {
+	Nodes map[string]int // The node that owns each IP address. Unknown addresses are node -1
+
+	fids map[flowKey]int
+
+	pids       map[packetKey]int // The pids seen since the current generation started
+	old_pids   map[packetKey]int // The pids of the previous generation, dropped when the next one starts
+	next_pid   int
+	expiry     float64 // Seconds a pid is remembered after it was last seen, or 0 to remember every pid
+	generation float64 // Start time of the current generation of pids
+}
+
+// Create an empty FlowTable
+func NewFlowTable() *FlowTable {
+	return &FlowTable{Nodes: make(map[string]int), fids: make(map[flowKey]int), pids: make(map[packetKey]int)}
+}
+
+// Forget a packet once it has not been seen for at least 'seconds', so the
+// table only holds the packets seen recently. Only set an expiry when every
+// reader that shares the table advances through time together, otherwise a
+// capture read after the others gets new pids for packets they already saw
+func (ft *FlowTable) SetPidExpiry(seconds float64) {
+	ft.expiry = seconds
+}
+
+// Get the fid of the flow, assigning a new one if the flow has not been seen
+func (ft *FlowTable) fid(protocol int, src_ip string, src_port int, dst_ip string, dst_port int) int {
+	key := flowKey{protocol, src_ip, src_port, dst_ip, dst_port}
+	if dst_ip < src_ip || (dst_ip == src_ip && dst_port < src_port) {
+		key = flowKey{protocol, dst_ip, dst_port, src_ip, src_port}
+	}
+	fid, ok := ft.fids[key]
+	if !ok {
+		fid = len(ft.fids) + 1
+		ft.fids[key] = fid
+	}
+	return fid
+}
+
+// Get the pid of the packet seen at 'time', assigning a new one if the packet
+// has not been seen or has expired
+func (ft *FlowTable) pid(key packetKey, time float64) int {
+	if ft.expiry > 0 && time >= ft.generation+ft.expiry {
+		ft.old_pids = ft.pids
+		ft.pids = make(map[packetKey]int)
+		ft.generation = time
+	}
+	if pid, ok := ft.pids[key]; ok {
+		return pid
+	}
+	pid, ok := ft.old_pids[key]
+	if !ok {
+		pid = ft.next_pid
+		ft.next_pid++
+	}
+	ft.pids[key] = pid
+	return pid
+}
+
+// Get the address of an endpoint, with node -1 if the owner of the IP is unknown
+func (ft *FlowTable) addr(ip string, port int) Addr {
+	node, ok := ft.Nodes[ip]
+	if !ok {
+		node = -1
+	}
+	return Addr{Node: node, Port: port}
+}
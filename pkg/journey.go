@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"math"
+	"sort"
+)
+
+// Fate is what finally happened to a packet
+type Fate int
+
+const (
+	InFlight  Fate = iota // The trace ended before the packet was delivered or dropped
+	Delivered             // The packet was received by its destination node
+	Dropped               // The packet was dropped by a queue
+)
+
+func (f Fate) String() string {
+	switch f {
+	case Delivered:
+		return "delivered"
+	case Dropped:
+		return "dropped"
+	}
+	return "in-flight"
+}
+
+// Hop is a packet's passage over a single link. Times that are missing from
+// the trace are NaN
+type Hop struct {
+	Link    Link
+	Enqueue float64
+	Dequeue float64
+	Receive float64
+	Dropped bool
+}
+
+// Get the time spent waiting in the link's queue
+func (h *Hop) QueueDelay() float64 {
+	return h.Dequeue - h.Enqueue
+}
+
+// Get the transmission plus propagation delay of the link
+func (h *Hop) LinkDelay() float64 {
+	return h.Receive - h.Dequeue
+}
+
+// Get the total time from enqueue to receive
+func (h *Hop) Delay() float64 {
+	return h.Receive - h.Enqueue
+}
+
+// Journey is the life of a single packet across every hop it took
+type Journey struct {
+	Pid   int
+	Fid   int
+	Type  PacketType
+	Size  int
+	Src   int     // The source node of the packet
+	Dst   int     // The destination node of the packet
+	First float64 // Time of the packet's first trace
+	Last  float64 // Time of the packet's last trace
+	Hops  []Hop
+	Fate  Fate
+}
+
+// Get the end-to-end delay from the first enqueue to the final receive, or
+// NaN if the packet was not delivered
+func (j *Journey) Delay() float64 {
+	if j.Fate != Delivered || len(j.Hops) == 0 {
+		return math.NaN()
+	}
+	return j.Hops[len(j.Hops)-1].Receive - j.Hops[0].Enqueue
+}
+
+// Get the hop over the link 'from' -> 'to', if the packet took it
+func (j *Journey) Hop(from int, to int) (*Hop, bool) {
+	for i := range j.Hops {
+		if j.Hops[i].Link.From == from && j.Hops[i].Link.To == to {
+			return &j.Hops[i], true
+		}
+	}
+	return nil, false
+}
+
+// Get the hop that the packet is currently on if it is the link 'link'
+func (j *Journey) current(link Link) *Hop {
+	if len(j.Hops) == 0 {
+		return nil
+	}
+	hop := &j.Hops[len(j.Hops)-1]
+	if hop.Link != link || hop.Dropped || !math.IsNaN(hop.Receive) {
+		return nil
+	}
+	return hop
+}
+
+// Start a new hop over 'link'
+func (j *Journey) start(link Link) *Hop {
+	j.Hops = append(j.Hops, Hop{Link: link, Enqueue: math.NaN(), Dequeue: math.NaN(), Receive: math.NaN()})
+	return &j.Hops[len(j.Hops)-1]
+}
+
+// JourneyBuilder follows every packet across its hops in a single pass over a
+// trace. Only the packets that are still in flight are kept in memory
+type JourneyBuilder struct {
+	journeys map[int]*Journey // A hashmap with {key, value} of {pid, journey}
+	hop_fn   func(*Journey, *Hop)
+	done_fn  func(*Journey)
+}
+
+// Create an empty JourneyBuilder
+func NewJourneyBuilder() *JourneyBuilder {
+	return &JourneyBuilder{journeys: make(map[int]*Journey)}
+}
+
+// Call 'fn' every time a packet is received at the end of a hop
+func (b *JourneyBuilder) OnHop(fn func(*Journey, *Hop)) {
+	b.hop_fn = fn
+}
+
+// Call 'fn' every time a packet is delivered or dropped
+func (b *JourneyBuilder) OnDone(fn func(*Journey)) {
+	b.done_fn = fn
+}
+
+// Add the next trace. Traces must arrive in time order
+func (b *JourneyBuilder) Add(trace *Trace) {
+	journey, ok := b.journeys[trace.Pid]
+	if !ok {
+		journey = &Journey{
+			Pid:   trace.Pid,
+			Fid:   trace.Fid,
+			Type:  trace.Type,
+			Size:  trace.Size,
+			Src:   trace.Src.Node,
+			Dst:   trace.Dst.Node,
+			First: trace.Time,
+		}
+		b.journeys[trace.Pid] = journey
+	}
+	journey.Last = trace.Time
+
+	// A trace may start in the middle of a hop, so missing hops are started on any event
+	link := Link{From: trace.From, To: trace.To}
+	hop := journey.current(link)
+	if hop == nil || trace.Event == Enqueue {
+		hop = journey.start(link)
+	}
+	switch trace.Event {
+	case Enqueue:
+		hop.Enqueue = trace.Time
+	case Dequeue:
+		hop.Dequeue = trace.Time
+	case Receive:
+		hop.Receive = trace.Time
+		if b.hop_fn != nil {
+			b.hop_fn(journey, hop)
+		}
+		if trace.To == journey.Dst {
+			b.finish(journey, Delivered)
+		}
+	case Drop:
+		hop.Dropped = true
+		b.finish(journey, Dropped)
+	}
+}
+
+func (b *JourneyBuilder) finish(journey *Journey, fate Fate) {
+	journey.Fate = fate
+	delete(b.journeys, journey.Pid)
+	if b.done_fn != nil {
+		b.done_fn(journey)
+	}
+}
+
+// Get the packets that are still in flight, sorted by pid
+func (b *JourneyBuilder) InFlight() []*Journey {
+	journeys := make([]*Journey, 0, len(b.journeys))
+	for _, journey := range b.journeys {
+		journeys = append(journeys, journey)
+	}
+	sort.Slice(journeys, func(i, j int) bool {
+		return journeys[i].Pid < journeys[j].Pid
+	})
+	return journeys
+}
+
+// Reconstruct the journey of every packet in a trace, in order of each
+// packet's first trace
+func BuildJourneys(traces []*Trace) []*Journey {
+	var journeys []*Journey
+	builder := NewJourneyBuilder()
+	builder.OnDone(func(journey *Journey) {
+		journeys = append(journeys, journey)
+	})
+	for _, trace := range traces {
+		builder.Add(trace)
+	}
+	journeys = append(journeys, builder.InFlight()...)
+	sort.SliceStable(journeys, func(i, j int) bool {
+		return journeys[i].First < journeys[j].First
+	})
+	return journeys
+}
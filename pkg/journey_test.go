@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	"math"
+	"testing"
+)
+
+// Three tcp packets from node 0 to node 3 over 0->1->2->3. Pid 0 is delivered
+// after waiting 20ms in the queue of 1->2, pid 1 is dropped by that queue and
+// pid 2 is still on 1->2 when the trace ends
+const journeyTrace = `+ 0.1 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+- 0.1 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
++ 0.105 0 1 tcp 1000 ------- 1 0.0 3.0 1 1
+- 0.105 0 1 tcp 1000 ------- 1 0.0 3.0 1 1
+r 0.11 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
++ 0.11 1 2 tcp 1000 ------- 1 0.0 3.0 0 0
+r 0.115 0 1 tcp 1000 ------- 1 0.0 3.0 1 1
++ 0.115 1 2 tcp 1000 ------- 1 0.0 3.0 1 1
+d 0.115 1 2 tcp 1000 ------- 1 0.0 3.0 1 1
++ 0.12 0 1 tcp 1000 ------- 1 0.0 3.0 2 2
+- 0.12 0 1 tcp 1000 ------- 1 0.0 3.0 2 2
+- 0.13 1 2 tcp 1000 ------- 1 0.0 3.0 0 0
+r 0.13 0 1 tcp 1000 ------- 1 0.0 3.0 2 2
++ 0.13 1 2 tcp 1000 ------- 1 0.0 3.0 2 2
+r 0.14 1 2 tcp 1000 ------- 1 0.0 3.0 0 0
++ 0.14 2 3 tcp 1000 ------- 1 0.0 3.0 0 0
+- 0.14 2 3 tcp 1000 ------- 1 0.0 3.0 0 0
+- 0.14 1 2 tcp 1000 ------- 1 0.0 3.0 2 2
+r 0.15 2 3 tcp 1000 ------- 1 0.0 3.0 0 0
+`
+
+// Report whether two times are equal, where NaN equals NaN
+func sameTime(a float64, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestBuildJourneys(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		fate  Fate
+		delay float64
+		hops  []Hop
+	}{
+		{Delivered, 0.05, []Hop{
+			{Link{0, 1}, 0.1, 0.1, 0.11, false},
+			{Link{1, 2}, 0.11, 0.13, 0.14, false},
+			{Link{2, 3}, 0.14, 0.14, 0.15, false},
+		}},
+		{Dropped, nan, []Hop{
+			{Link{0, 1}, 0.105, 0.105, 0.115, false},
+			{Link{1, 2}, 0.115, nan, nan, true},
+		}},
+		{InFlight, nan, []Hop{
+			{Link{0, 1}, 0.12, 0.12, 0.13, false},
+			{Link{1, 2}, 0.13, 0.14, nan, false},
+		}},
+	}
+	journeys := BuildJourneys(parseTestTraces(t, journeyTrace))
+	if len(journeys) != len(tests) {
+		t.Fatalf("got %d journeys, want %d", len(journeys), len(tests))
+	}
+	for pid, test := range tests {
+		journey := journeys[pid]
+		if journey.Pid != pid || journey.Fate != test.fate || journey.Src != 0 || journey.Dst != 3 {
+			t.Errorf("journey %d: pid %d, %s from %d to %d, want pid %d, %s from 0 to 3", pid, journey.Pid, journey.Fate, journey.Src, journey.Dst, pid, test.fate)
+		}
+		if !sameTime(journey.Delay(), test.delay) {
+			t.Errorf("pid %d: delay = %g, want %g", pid, journey.Delay(), test.delay)
+		}
+		if len(journey.Hops) != len(test.hops) {
+			t.Errorf("pid %d: hops = %+v, want %+v", pid, journey.Hops, test.hops)
+			continue
+		}
+		for i, want := range test.hops {
+			got := journey.Hops[i]
+			if got.Link != want.Link || got.Dropped != want.Dropped || !sameTime(got.Enqueue, want.Enqueue) ||
+				!sameTime(got.Dequeue, want.Dequeue) || !sameTime(got.Receive, want.Receive) {
+				t.Errorf("pid %d: hop %d = %+v, want %+v", pid, i, got, want)
+			}
+		}
+	}
+
+	// The delivered packet waited 20ms in the queue of 1->2, then spent 10ms on the link
+	hop, ok := journeys[0].Hop(1, 2)
+	if !ok || !sameTime(hop.QueueDelay(), 0.02) || !sameTime(hop.LinkDelay(), 0.01) || !sameTime(hop.Delay(), 0.03) {
+		t.Errorf("hop 1->2 of pid 0 = %+v, want 20ms queueing and 10ms on the link", hop)
+	}
+}
+
+func TestJourneyBuilderCallbacks(t *testing.T) {
+	builder := NewJourneyBuilder()
+	var hops []Link
+	var done []int
+	builder.OnHop(func(journey *Journey, hop *Hop) {
+		if journey.Pid == 0 {
+			hops = append(hops, hop.Link)
+		}
+	})
+	builder.OnDone(func(journey *Journey) {
+		done = append(done, journey.Pid)
+	})
+	for _, trace := range parseTestTraces(t, journeyTrace) {
+		builder.Add(trace)
+	}
+
+	// Only the packet in flight is still held once the others are done
+	if len(hops) != 3 || hops[0] != (Link{0, 1}) || hops[2] != (Link{2, 3}) {
+		t.Errorf("hops of pid 0 = %v, want 0->1, 1->2 and 2->3", hops)
+	}
+	if len(done) != 2 || done[0] != 1 || done[1] != 0 {
+		t.Errorf("done pids = %v, want the drop of 1 then the delivery of 0", done)
+	}
+	in_flight := builder.InFlight()
+	if len(in_flight) != 1 || in_flight[0].Pid != 2 || in_flight[0].Fate != InFlight {
+		t.Errorf("in flight = %d journeys, want pid 2 only", len(in_flight))
+	}
+}
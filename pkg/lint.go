@@ -0,0 +1,100 @@
+package pkg
+
+import "fmt"
+
+// LintIssue is a line of a trace that breaks a causal invariant
+type LintIssue struct {
+	Line int // The 1-based line number of the offending trace
+	Msg  string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("line %d: %s", i.Line, i.Msg)
+}
+
+// The progress of a packet across a single link
+type linkPacket struct {
+	link Link
+	pid  int
+}
+
+// TraceLinter checks the causal invariants of an ns2 trace in a single pass:
+//
+//   - time never goes backwards
+//   - a packet is dequeued from a link only after it was enqueued on it
+//   - a packet is received from a link only after it was dequeued from it
+//   - a packet is never received after it was dropped
+type TraceLinter struct {
+	last_time float64
+	last_line int
+	progress  map[linkPacket]Event // The last event of a packet on a link
+	dropped   map[int]int          // A hashmap with {key, value} of {pid, line of event 'd'}
+	issues    []LintIssue
+}
+
+// Create an empty TraceLinter
+func NewTraceLinter() *TraceLinter {
+	return &TraceLinter{progress: make(map[linkPacket]Event), dropped: make(map[int]int)}
+}
+
+// Check the next trace, read from line 'line'
+func (l *TraceLinter) Check(line int, trace *Trace) {
+	// Report only the step back, then continue from the new time
+	if l.last_line > 0 && trace.Time < l.last_time {
+		l.report(line, "time %g goes backwards from %g at line %d", trace.Time, l.last_time, l.last_line)
+	}
+	l.last_time = trace.Time
+	l.last_line = line
+
+	// A dropped packet should have no later events, so the next event of its
+	// pid ends the check and the pid is forgotten
+	if drop_line, ok := l.dropped[trace.Pid]; ok {
+		if trace.Event == Receive {
+			l.report(line, "pid %d received after being dropped at line %d", trace.Pid, drop_line)
+		}
+		delete(l.dropped, trace.Pid)
+	}
+
+	key := linkPacket{link: Link{From: trace.From, To: trace.To}, pid: trace.Pid}
+	switch trace.Event {
+	case Enqueue:
+		l.progress[key] = Enqueue
+	case Dequeue:
+		if l.progress[key] != Enqueue {
+			l.report(line, "pid %d dequeued from %d->%d without being enqueued", trace.Pid, trace.From, trace.To)
+		}
+		l.progress[key] = Dequeue
+	case Receive:
+		if l.progress[key] != Dequeue {
+			l.report(line, "pid %d received from %d->%d without being dequeued", trace.Pid, trace.From, trace.To)
+		}
+		delete(l.progress, key)
+	case Drop:
+		l.dropped[trace.Pid] = line
+		delete(l.progress, key)
+	}
+}
+
+func (l *TraceLinter) report(line int, format string, args ...interface{}) {
+	l.issues = append(l.issues, LintIssue{Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Return every issue found so far in line order
+func (l *TraceLinter) Result() []LintIssue {
+	return l.issues
+}
+
+// Check every trace in the file and return the issues found. A malformed line
+// stops the check with a *ParseError
+func LintTraceFile(file string) ([]LintIssue, error) {
+	reader, err := OpenTraceFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	linter := NewTraceLinter()
+	for reader.Next() {
+		linter.Check(reader.Line(), reader.Trace())
+	}
+	return linter.Result(), reader.Err()
+}
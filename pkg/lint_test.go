@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+// Lint hand-written trace lines and return the issues found
+func lintTestTrace(t *testing.T, text string) ([]LintIssue, *TraceLinter) {
+	t.Helper()
+	linter := NewTraceLinter()
+	for i, trace := range parseTestTraces(t, text) {
+		linter.Check(i+1, trace)
+	}
+	return linter.Result(), linter
+}
+
+func TestTraceLinterRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace string
+		want  []string
+	}{
+		{"clean", hopLines(0, []int{0, 1, 2, 3}, "tcp", 1, 0, -1) + hopLines(1, []int{0, 1, 2, 3}, "tcp", 1, 1, 1), nil},
+		{"time goes backwards", `+ 1.0 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+- 0.5 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+r 0.6 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+`, []string{"line 2: time 0.5 goes backwards from 1 at line 1"}},
+		{"dequeue without enqueue", `- 1.0 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+r 1.1 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+`, []string{"line 1: pid 0 dequeued from 0->1 without being enqueued"}},
+		{"receive without dequeue", `+ 1.0 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+r 1.1 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+`, []string{"line 2: pid 0 received from 0->1 without being dequeued"}},
+		{"receive after drop", `+ 1.0 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+- 1.0 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+d 1.05 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+r 1.1 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+`, []string{
+			"line 4: pid 0 received after being dropped at line 3",
+			"line 4: pid 0 received from 0->1 without being dequeued",
+		}},
+	}
+	for _, test := range tests {
+		issues, _ := lintTestTrace(t, test.trace)
+		var got []string
+		for _, issue := range issues {
+			got = append(got, issue.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: got issues\n%s\nwant\n%s", test.name, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+func TestTraceLinterForgetsDrops(t *testing.T) {
+	// Drop pid 0 and 1, then see pid 0 again, which ends the check of pid 0 only
+	_, linter := lintTestTrace(t, `+ 1.0 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+d 1.0 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
++ 1.0 0 1 tcp 1000 ------- 1 0.0 3.0 1 1
+d 1.0 0 1 tcp 1000 ------- 1 0.0 3.0 1 1
+r 1.1 0 1 tcp 1000 ------- 1 0.0 3.0 0 0
+`)
+	if len(linter.dropped) != 1 {
+		t.Errorf("%d dropped pids remembered, want only pid 1", len(linter.dropped))
+	}
+	if _, ok := linter.dropped[1]; !ok {
+		t.Error("pid 1 was forgotten before its check was done")
+	}
+	if len(linter.progress) != 0 {
+		t.Errorf("%d packets still in progress, want 0", len(linter.progress))
+	}
+}
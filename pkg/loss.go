@@ -0,0 +1,216 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GilbertElliott is a two-state Markov model of packet loss. Before every
+// packet the channel moves from Good to Bad with probability P and from Bad
+// to Good with probability R, and it loses the packet with probability
+// LossGood in the Good state and LossBad in the Bad state
+type GilbertElliott struct {
+	P        float64
+	R        float64
+	LossGood float64
+	LossBad  float64
+}
+
+// Fit a GilbertElliott model to the outcomes of consecutive packets from the
+// transition counts between them. The Bad state is a lost packet, so this is
+// the Gilbert special case where LossGood is 0 and LossBad is 1. P is NaN if
+// no packet got through and R is NaN if no packet was lost
+func FitGilbertElliott(lost []bool) GilbertElliott {
+	var good_good, good_bad, bad_good, bad_bad int
+	for i := 1; i < len(lost); i++ {
+		switch {
+		case !lost[i-1] && !lost[i]:
+			good_good++
+		case !lost[i-1] && lost[i]:
+			good_bad++
+		case lost[i-1] && !lost[i]:
+			bad_good++
+		default:
+			bad_bad++
+		}
+	}
+	return GilbertElliott{
+		P:        ratio(good_bad, good_good+good_bad),
+		R:        ratio(bad_good, bad_good+bad_bad),
+		LossGood: 0,
+		LossBad:  1,
+	}
+}
+
+// Get 'a' over 'b', or NaN if 'b' is 0
+func ratio(a int, b int) float64 {
+	if b == 0 {
+		return math.NaN()
+	}
+	return float64(a) / float64(b)
+}
+
+// Get the long-run loss rate of the model
+func (m GilbertElliott) LossRate() float64 {
+	return (m.R*m.LossGood + m.P*m.LossBad) / (m.P + m.R)
+}
+
+// Get the expected number of consecutive packets spent in the Bad state
+func (m GilbertElliott) MeanBurst() float64 {
+	return 1 / m.R
+}
+
+// LossReport describes how a flow lost its packets
+type LossReport struct {
+	Sent   int       // Packets the source sent, counting every retransmission
+	Lost   int       // Packets dropped anywhere on the way
+	Rate   float64   // Lost over Sent, or NaN if nothing was sent
+	Bursts []int     // Lengths of the runs of consecutive lost packets, in the order they were sent
+	Gaps   []float64 // Seconds between consecutive drops
+	Model  GilbertElliott
+}
+
+// Get the average burst length, or NaN if nothing was lost
+func (r *LossReport) MeanBurst() float64 {
+	if len(r.Bursts) == 0 {
+		return math.NaN()
+	}
+	var sum int
+	for _, burst := range r.Bursts {
+		sum += burst
+	}
+	return float64(sum) / float64(len(r.Bursts))
+}
+
+// Get the longest burst, or 0 if nothing was lost
+func (r *LossReport) MaxBurst() int {
+	var max int
+	for _, burst := range r.Bursts {
+		if burst > max {
+			max = burst
+		}
+	}
+	return max
+}
+
+// Get the burst-length distribution as a hashmap with {key, value} of {burst length, number of bursts}
+func (r *LossReport) BurstHistogram() map[int]int {
+	histogram := make(map[int]int)
+	for _, burst := range r.Bursts {
+		histogram[burst]++
+	}
+	return histogram
+}
+
+// LossMeter follows every packet of a single flow in a single pass over a
+// trace to tell which ones were lost. A packet is sent when its source
+// enqueues it, and it is lost if it is dropped anywhere before it reaches its
+// destination. The trace should already be filtered by fid and packet type
+type LossMeter struct {
+	span TimeSpan
+
+	pending    map[int]int // A hashmap with {key, value} of {pid, index into lost} of packets still on the way
+	lost       []bool      // The outcome of every sent packet, in the order they were sent
+	drop_times []float64
+}
+
+// Create a LossMeter that only follows packets sent inside 'span'
+func NewLossMeter(span TimeSpan) *LossMeter {
+	return &LossMeter{span: span, pending: make(map[int]int)}
+}
+
+// Add the next trace. Traces must arrive in time order
+func (m *LossMeter) Add(trace *Trace) {
+	switch trace.Event {
+	case Enqueue:
+		if trace.From != trace.Src.Node || !m.span.Contains(trace.Time) {
+			return
+		}
+		if _, ok := m.pending[trace.Pid]; !ok {
+			m.pending[trace.Pid] = len(m.lost)
+			m.lost = append(m.lost, false)
+		}
+	case Drop:
+		if i, ok := m.pending[trace.Pid]; ok {
+			m.lost[i] = true
+			m.drop_times = append(m.drop_times, trace.Time)
+			delete(m.pending, trace.Pid)
+		}
+	case Receive:
+		if trace.To == trace.Dst.Node {
+			delete(m.pending, trace.Pid)
+		}
+	}
+}
+
+// Return the loss report. Packets still on the way count as delivered
+func (m *LossMeter) Result() *LossReport {
+	report := &LossReport{Sent: len(m.lost), Model: FitGilbertElliott(m.lost)}
+	burst := 0
+	for _, lost := range m.lost {
+		if lost {
+			report.Lost++
+			burst++
+		} else if burst > 0 {
+			report.Bursts = append(report.Bursts, burst)
+			burst = 0
+		}
+	}
+	if burst > 0 {
+		report.Bursts = append(report.Bursts, burst)
+	}
+	report.Rate = ratio(report.Lost, report.Sent)
+	for i := 1; i < len(m.drop_times); i++ {
+		report.Gaps = append(report.Gaps, m.drop_times[i]-m.drop_times[i-1])
+	}
+	return report
+}
+
+// Write a CSV row for every flow's loss report, sorted by fid
+func WriteLossReports(w io.Writer, reports map[int]*LossReport) error {
+	fids := make([]int, 0, len(reports))
+	for fid := range reports {
+		fids = append(fids, fid)
+	}
+	sort.Ints(fids)
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"fid", "sent", "lost", "loss_rate", "bursts", "mean_burst", "max_burst", "burst_histogram",
+		"mean_gap", "gilbert_p", "gilbert_r"})
+	for _, fid := range fids {
+		report := reports[fid]
+		histogram := report.BurstHistogram()
+		lengths := make([]int, 0, len(histogram))
+		for length := range histogram {
+			lengths = append(lengths, length)
+		}
+		sort.Ints(lengths)
+		buckets := make([]string, len(lengths))
+		for i, length := range lengths {
+			buckets[i] = strconv.Itoa(length) + ":" + strconv.Itoa(histogram[length])
+		}
+		mean_gap := math.NaN()
+		if len(report.Gaps) > 0 {
+			mean_gap = Mean(report.Gaps)
+		}
+		writer.Write([]string{
+			strconv.Itoa(fid),
+			strconv.Itoa(report.Sent),
+			strconv.Itoa(report.Lost),
+			strconv.FormatFloat(report.Rate, 'f', -1, 64),
+			strconv.Itoa(len(report.Bursts)),
+			strconv.FormatFloat(report.MeanBurst(), 'f', -1, 64),
+			strconv.Itoa(report.MaxBurst()),
+			strings.Join(buckets, " "),
+			strconv.FormatFloat(mean_gap, 'f', -1, 64),
+			strconv.FormatFloat(report.Model.P, 'f', -1, 64),
+			strconv.FormatFloat(report.Model.R, 'f', -1, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// Ten packets where the 3rd, 4th and 8th are lost: 4 good->good, 2 good->bad,
+// 1 bad->bad and 2 bad->good transitions
+var lossPattern = []bool{false, false, true, true, false, false, false, true, false, false}
+
+func TestFitGilbertElliott(t *testing.T) {
+	model := FitGilbertElliott(lossPattern)
+	if math.Abs(model.P-1.0/3) > 1e-9 || math.Abs(model.R-2.0/3) > 1e-9 || model.LossGood != 0 || model.LossBad != 1 {
+		t.Errorf("model = %+v, want P 1/3 and R 2/3", model)
+	}
+	if math.Abs(model.LossRate()-1.0/3) > 1e-9 || math.Abs(model.MeanBurst()-1.5) > 1e-9 {
+		t.Errorf("loss rate = %g and mean burst = %g, want 1/3 and 1.5", model.LossRate(), model.MeanBurst())
+	}
+
+	// Without a lost packet there is no Bad state to leave, and the other way round
+	none := FitGilbertElliott([]bool{false, false, false})
+	if none.P != 0 || !math.IsNaN(none.R) {
+		t.Errorf("nothing lost: model = %+v, want P 0 and R NaN", none)
+	}
+	all := FitGilbertElliott([]bool{true, true, true})
+	if !math.IsNaN(all.P) || all.R != 0 {
+		t.Errorf("everything lost: model = %+v, want P NaN and R 0", all)
+	}
+}
+
+// Send a packet from node 0 to node 2 every 100ms, dropping the lost ones at the queue of 1->2
+func lossTrace() string {
+	var text string
+	for pid, lost := range lossPattern {
+		drop_hop := -1
+		if lost {
+			drop_hop = 1
+		}
+		text += hopLines(float64(pid)*0.1, []int{0, 1, 2}, "cbr", 1, pid, drop_hop)
+	}
+	return text
+}
+
+func TestLossMeter(t *testing.T) {
+	meter := NewLossMeter(FullSpan())
+	for _, trace := range parseTestTraces(t, lossTrace()) {
+		meter.Add(trace)
+	}
+	report := meter.Result()
+	if report.Sent != 10 || report.Lost != 3 || math.Abs(report.Rate-0.3) > 1e-9 {
+		t.Errorf("sent %d, lost %d, rate %g, want 10, 3 and 0.3", report.Sent, report.Lost, report.Rate)
+	}
+	if len(report.Bursts) != 2 || report.Bursts[0] != 2 || report.Bursts[1] != 1 || report.MaxBurst() != 2 || report.MeanBurst() != 1.5 {
+		t.Errorf("bursts = %v, want [2 1]", report.Bursts)
+	}
+	// The drops happen 10ms after each lost packet is sent, at 0.21, 0.31 and 0.71
+	if len(report.Gaps) != 2 || math.Abs(report.Gaps[0]-0.1) > 1e-9 || math.Abs(report.Gaps[1]-0.4) > 1e-9 {
+		t.Errorf("gaps = %v, want [0.1 0.4]", report.Gaps)
+	}
+	if math.Abs(report.Model.P-1.0/3) > 1e-9 || math.Abs(report.Model.R-2.0/3) > 1e-9 {
+		t.Errorf("model = %+v, want P 1/3 and R 2/3", report.Model)
+	}
+	if len(meter.pending) != 0 {
+		t.Errorf("%d packets still pending, want 0", len(meter.pending))
+	}
+}
+
+func TestWriteLossReportsWithoutLoss(t *testing.T) {
+	meter := NewLossMeter(FullSpan())
+	for _, trace := range parseTestTraces(t, hopLines(0, []int{0, 1, 2}, "cbr", 1, 0, -1)+hopLines(0.1, []int{0, 1, 2}, "cbr", 1, 1, -1)) {
+		meter.Add(trace)
+	}
+	var out strings.Builder
+	if err := WriteLossReports(&out, map[int]*LossReport{1: meter.Result()}); err != nil {
+		t.Fatal(err)
+	}
+	want := "fid,sent,lost,loss_rate,bursts,mean_burst,max_burst,burst_histogram,mean_gap,gilbert_p,gilbert_r\n" +
+		"1,2,0,0,0,NaN,0,,NaN,0,NaN\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,249 @@
+package pkg
+
+import "math"
+
+// ThroughputMeter calculates throughput vs time in a single pass over a trace.
+// The time series comes from a Window, and the average is the total bytes
+// received over the span. If the span is unbounded, the average is the bytes
+// received after the first packet over the time from the first to the last
+// receive. Throughput is in Mbps
+type ThroughputMeter struct {
+	from_node int
+	to_node   int
+	span      TimeSpan
+	window    Window
+
+	packets       int
+	bytes         int
+	first_size    int
+	first_receive float64
+	last_receive  float64
+}
+
+// Create a ThroughputMeter for the link 'from_node' -> 'to_node' that only
+// counts packets received inside 'span', with a sliding window of 'window_size' seconds
+func NewThroughputMeter(from_node int, to_node int, span TimeSpan, window_size float64) *ThroughputMeter {
+	return NewWindowedThroughputMeter(from_node, to_node, span, NewSlidingWindow(window_size))
+}
+
+// Create a ThroughputMeter for the link 'from_node' -> 'to_node' that only
+// counts packets received inside 'span', with any Window
+func NewWindowedThroughputMeter(from_node int, to_node int, span TimeSpan, window Window) *ThroughputMeter {
+	return &ThroughputMeter{from_node: from_node, to_node: to_node, span: span, window: window}
+}
+
+// Add the next trace. Traces must arrive in time order
+func (m *ThroughputMeter) Add(trace *Trace) {
+	if trace.Event != Receive || trace.From != m.from_node || trace.To != m.to_node || !m.span.Contains(trace.Time) {
+		return
+	}
+	if m.packets == 0 {
+		m.first_receive = trace.Time
+		m.first_size = trace.Size
+	}
+	m.packets++
+	m.bytes += trace.Size
+	m.last_receive = trace.Time
+	m.window.Add(trace.Time, trace.Size)
+}
+
+// Get the average throughput in Mbps
+func (m *ThroughputMeter) Average() float64 {
+	return averageMbps(m.span, m.bytes, m.first_size, m.first_receive, m.last_receive)
+}
+
+// Get the average rate in Mbps of 'bytes' received inside 'span'. A bounded
+// span averages over its length. An unbounded span averages over the time
+// from the 'first' to the 'last' receive, without the first packet's
+// 'first_size' bytes since they arrived before that time started
+func averageMbps(span TimeSpan, bytes int, first_size int, first float64, last float64) float64 {
+	if !math.IsInf(span.Start, 0) && !math.IsInf(span.End, 0) {
+		return toMbps(bytes, span.Length())
+	}
+	if last <= first {
+		return 0
+	}
+	return toMbps(bytes-first_size, last-first)
+}
+
+// Return slice times, slice throughputs, and average throughput
+func (m *ThroughputMeter) Result() ([]float64, []float64, float64) {
+	if m.packets == 0 {
+		return nil, nil, 0
+	}
+	time_ticks, throughput_ticks := m.window.Result()
+	return time_ticks, throughput_ticks, m.Average()
+}
+
+// LatencyMeter calculates latency vs time in a single pass over a trace from
+// the reconstructed packet journeys. Only the packets in flight are kept in memory
+type LatencyMeter struct {
+	link     Link
+	span     TimeSpan
+	journeys *JourneyBuilder
+
+	time_ticks    []float64
+	latency_ticks []float64
+}
+
+// Create a LatencyMeter for the link 'from_node' -> 'to_node' that only
+// records the latency of packets received inside 'span'
+func NewLatencyMeter(from_node int, to_node int, span TimeSpan) *LatencyMeter {
+	m := &LatencyMeter{link: Link{From: from_node, To: to_node}, span: span, journeys: NewJourneyBuilder()}
+	m.journeys.OnHop(m.addHop)
+	return m
+}
+
+// Add the next trace. Traces must arrive in time order
+func (m *LatencyMeter) Add(trace *Trace) {
+	m.journeys.Add(trace)
+}
+
+// Record the latency of a packet that completed a hop. A dropped packet never
+// completes the hop it was dropped on
+func (m *LatencyMeter) addHop(journey *Journey, hop *Hop) {
+	if hop.Link != m.link || math.IsNaN(hop.Enqueue) || !m.span.Contains(hop.Receive) {
+		return
+	}
+	m.time_ticks = append(m.time_ticks, hop.Receive)
+	m.latency_ticks = append(m.latency_ticks, hop.Delay())
+}
+
+// Return slice times, slice latencies, and average latency
+func (m *LatencyMeter) Result() ([]float64, []float64, float64) {
+	return m.time_ticks, m.latency_ticks, Mean(m.latency_ticks)
+}
+
+// SeriesMeter is a single pass meter that produces a time series and its average
+type SeriesMeter interface {
+	Add(trace *Trace)
+	Result() ([]float64, []float64, float64)
+}
+
+// DelayMeter calculates the end-to-end one-way delay of a flow, from a
+// packet's first enqueue at its source node to its final receive at its
+// destination node. Feed it the traces of a single flow
+type DelayMeter struct {
+	span     TimeSpan
+	journeys *JourneyBuilder
+
+	time_ticks  []float64
+	delay_ticks []float64
+}
+
+// Create a DelayMeter that only records packets delivered inside 'span'
+func NewDelayMeter(span TimeSpan) *DelayMeter {
+	m := &DelayMeter{span: span, journeys: NewJourneyBuilder()}
+	m.journeys.OnDone(m.addJourney)
+	return m
+}
+
+// Add the next trace. Traces must arrive in time order
+func (m *DelayMeter) Add(trace *Trace) {
+	m.journeys.Add(trace)
+}
+
+// Record the delay of a delivered packet whose whole journey is in the trace
+func (m *DelayMeter) addJourney(journey *Journey) {
+	if journey.Fate != Delivered || journey.Hops[0].Link.From != journey.Src || math.IsNaN(journey.Hops[0].Enqueue) {
+		return
+	}
+	if !m.span.Contains(journey.Last) {
+		return
+	}
+	m.time_ticks = append(m.time_ticks, journey.Last)
+	m.delay_ticks = append(m.delay_ticks, journey.Delay())
+}
+
+// Return slice times, slice delays, and average delay
+func (m *DelayMeter) Result() ([]float64, []float64, float64) {
+	return m.time_ticks, m.delay_ticks, Mean(m.delay_ticks)
+}
+
+// Get the descriptive statistics of the delays
+func (m *DelayMeter) Summary() Summary {
+	return Summarize(m.delay_ticks)
+}
+
+// GoodputMeter calculates the goodput and retransmissions of a flow in a
+// single pass. Goodput counts the bytes of each sequence number delivered to
+// the destination node once, so retransmitted and duplicate packets do not
+// inflate it. It is averaged the same way as ThroughputMeter.Average so the
+// two can be compared. Feed it the traces of a single flow
+type GoodputMeter struct {
+	span TimeSpan
+
+	sent      map[int]bool // Sequence numbers sent by the source
+	delivered map[int]bool // Sequence numbers delivered to the destination
+
+	first_delivery float64
+	first_size     int // The size of the first delivered packet
+	last_delivery  float64
+
+	sends           int // Every data packet sent by the source
+	retransmissions int // Data packets sent with a sequence number that was already sent
+	goodput_bytes   int
+}
+
+// Create a GoodputMeter that only counts packets sent and delivered inside 'span'
+func NewGoodputMeter(span TimeSpan) *GoodputMeter {
+	return &GoodputMeter{span: span, sent: make(map[int]bool), delivered: make(map[int]bool)}
+}
+
+// Add the next trace. Traces must arrive in time order
+func (m *GoodputMeter) Add(trace *Trace) {
+	if trace.Type == Ack || !m.span.Contains(trace.Time) {
+		return
+	}
+	switch {
+	case trace.Event == Enqueue && trace.From == trace.Src.Node:
+		m.sends++
+		if m.sent[trace.Seq] {
+			m.retransmissions++
+		}
+		m.sent[trace.Seq] = true
+	case trace.Event == Receive && trace.To == trace.Dst.Node:
+		if !m.delivered[trace.Seq] {
+			if len(m.delivered) == 0 {
+				m.first_delivery = trace.Time
+				m.first_size = trace.Size
+			}
+			m.delivered[trace.Seq] = true
+			m.goodput_bytes += trace.Size
+			m.last_delivery = trace.Time
+		}
+	}
+}
+
+// Return the goodput in Mbps, the number of retransmissions, and the
+// retransmission ratio (retransmissions over packets sent)
+func (m *GoodputMeter) Result() (float64, int, float64) {
+	if m.sends == 0 {
+		return 0, 0, 0
+	}
+	goodput := averageMbps(m.span, m.goodput_bytes, m.first_size, m.first_delivery, m.last_delivery)
+	return goodput, m.retransmissions, float64(m.retransmissions) / float64(m.sends)
+}
+
+// DropCounter counts dropped packets in a single pass over a trace
+type DropCounter struct {
+	span  TimeSpan
+	drops int
+}
+
+// Create an empty DropCounter that only counts drops inside 'span'
+func NewDropCounter(span TimeSpan) *DropCounter {
+	return &DropCounter{span: span}
+}
+
+// Add the next trace
+func (c *DropCounter) Add(trace *Trace) {
+	if trace.Event == Drop && c.span.Contains(trace.Time) {
+		c.drops++
+	}
+}
+
+// Return the number of dropped packets
+func (c *DropCounter) Result() int {
+	return c.drops
+}
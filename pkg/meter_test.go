@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDelayMeter(t *testing.T) {
+	// Only the delivered pid 0 has a delay. A packet first seen in the middle
+	// of its path has no known start, so it is left out too
+	traces := parseTestTraces(t, journeyTrace+`- 0.2 1 2 tcp 1000 ------- 1 0.0 3.0 3 3
+r 0.21 1 2 tcp 1000 ------- 1 0.0 3.0 3 3
++ 0.21 2 3 tcp 1000 ------- 1 0.0 3.0 3 3
+- 0.21 2 3 tcp 1000 ------- 1 0.0 3.0 3 3
+r 0.22 2 3 tcp 1000 ------- 1 0.0 3.0 3 3
+`)
+
+	meter := NewDelayMeter(FullSpan())
+	for _, trace := range traces {
+		meter.Add(trace)
+	}
+	times, delays, average := meter.Result()
+	checkSeries(t, "delay", times, delays, []float64{0.15}, []float64{0.05})
+	if math.Abs(average-0.05) > 1e-9 {
+		t.Errorf("average delay = %g, want 0.05", average)
+	}
+
+	// A delivery exactly at the end of the span is outside it
+	meter = NewDelayMeter(AbsoluteSpan(0, 0.15))
+	for _, trace := range traces {
+		meter.Add(trace)
+	}
+	if times, _, average := meter.Result(); len(times) != 0 || !math.IsNaN(average) {
+		t.Errorf("span [0, 0.15): got delays at %v with average %g, want none", times, average)
+	}
+}
+
+// Four 1 Mb segments from node 0 to node 1, where segment 1 is sent and
+// delivered twice
+const duplicateTrace = `+ 0.1 0 1 tcp 125000 ------- 1 0.0 1.0 0 0
+- 0.1 0 1 tcp 125000 ------- 1 0.0 1.0 0 0
+r 0.2 0 1 tcp 125000 ------- 1 0.0 1.0 0 0
++ 0.3 0 1 tcp 125000 ------- 1 0.0 1.0 1 1
+- 0.3 0 1 tcp 125000 ------- 1 0.0 1.0 1 1
+r 0.4 0 1 tcp 125000 ------- 1 0.0 1.0 1 1
++ 0.5 0 1 tcp 125000 ------- 1 0.0 1.0 1 2
+- 0.5 0 1 tcp 125000 ------- 1 0.0 1.0 1 2
+r 0.6 0 1 tcp 125000 ------- 1 0.0 1.0 1 2
++ 0.7 0 1 tcp 125000 ------- 1 0.0 1.0 2 3
+- 0.7 0 1 tcp 125000 ------- 1 0.0 1.0 2 3
+r 0.8 0 1 tcp 125000 ------- 1 0.0 1.0 2 3
+`
+
+func TestGoodputExcludesDuplicates(t *testing.T) {
+	span := AbsoluteSpan(0, 1)
+	throughput := NewThroughputMeter(0, 1, span, 1)
+	goodput := NewGoodputMeter(span)
+	for _, trace := range parseTestTraces(t, duplicateTrace) {
+		throughput.Add(trace)
+		goodput.Add(trace)
+	}
+
+	// Throughput counts all 4 Mb received, goodput the 3 distinct segments
+	if average := throughput.Average(); math.Abs(average-4) > 1e-9 {
+		t.Errorf("throughput = %g Mbps, want 4", average)
+	}
+	mbps, retransmissions, ratio := goodput.Result()
+	if math.Abs(mbps-3) > 1e-9 || retransmissions != 1 || ratio != 0.25 {
+		t.Errorf("goodput = %g Mbps with %d retransmissions (ratio %g), want 3 Mbps with 1 (ratio 0.25)", mbps, retransmissions, ratio)
+	}
+}
@@ -0,0 +1,385 @@
+package pkg
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Ns3Device identifies a net device in an ns3 trace by its node and device index
+type Ns3Device struct {
+	Node   int
+	Device int
+}
+
+// Ns3Topology fills in what an ns3 ASCII trace leaves out. An ns3 trace line
+// only names the device the event happened on, so the other end of the link
+// and the nodes that own each IP address have to be known separately
+type Ns3Topology struct {
+	Peers map[Ns3Device]Ns3Device // The device at the other end of each point-to-point link
+	Nodes map[string]int          // The node that owns each IP address
+}
+
+// Create an empty Ns3Topology
+func NewNs3Topology() *Ns3Topology {
+	return &Ns3Topology{Peers: make(map[Ns3Device]Ns3Device), Nodes: make(map[string]int)}
+}
+
+var errNs3Path = errors.New("missing /NodeList/<node>/DeviceList/<device> path")
+
+// A single packet event of an ns3 trace before it is mapped onto a Trace
+type ns3Record struct {
+	trace  *Trace
+	device Ns3Device
+	dst_ip string
+}
+
+// The IP identity of a packet. It stays the same on every hop
+type ns3PacketKey struct {
+	src_ip   string
+	dst_ip   string
+	protocol int
+	id       int
+}
+
+// The pid of a packet and the device it was first enqueued on
+type ns3Packet struct {
+	pid    int
+	source Ns3Device
+}
+
+// ns3Parser maps ns3 ASCII trace lines onto the Trace model. ns3 has no
+// packet ids or flow ids, so packets are identified by their IP header and
+// flows by their 5-tuple, both numbered in order of first appearance
+type ns3Parser struct {
+	topology *Ns3Topology
+	nodes    map[string]int // The topology's nodes plus the ones learned from packet sources
+	flows    *FlowTable
+	packets  map[ns3PacketKey]ns3Packet // Only the packets still in flight
+	next_pid int
+}
+
+// Create a parser over 'topology'. The topology is only read, so several
+// readers can share it
+func newNs3Parser(topology *Ns3Topology) *ns3Parser {
+	if topology == nil {
+		topology = NewNs3Topology()
+	}
+	nodes := make(map[string]int, len(topology.Nodes))
+	for ip, node := range topology.Nodes {
+		nodes[ip] = node
+	}
+	return &ns3Parser{topology: topology, nodes: nodes, flows: NewFlowTable(), packets: make(map[ns3PacketKey]ns3Packet)}
+}
+
+// Parse a single line of an ns3 ASCII trace into a Trace. Lines that are not
+// IPv4 TCP or UDP packet events on a device return a nil Trace and no error
+func (p *ns3Parser) parseLine(line string) (*Trace, error) {
+	rec, err := p.parseRecord(line)
+	if rec == nil || err != nil {
+		return nil, err
+	}
+	trace := rec.trace
+	peer, ok := p.topology.Peers[rec.device]
+	peer_node := -1
+	if ok {
+		peer_node = peer.Node
+	}
+	if trace.Event == Receive {
+		trace.From, trace.To = peer_node, rec.device.Node
+	} else {
+		trace.From, trace.To = rec.device.Node, peer_node
+	}
+	trace.Dst.Node = -1
+	if node, ok := p.nodes[rec.dst_ip]; ok {
+		trace.Dst.Node = node
+	}
+	return trace, nil
+}
+
+// Parse the fields of an ns3 ASCII trace line that do not depend on the topology
+func (p *ns3Parser) parseRecord(line string) (*ns3Record, error) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 3 {
+		return nil, &ParseError{Field: -1, Text: line, Err: errFieldCount}
+	}
+	if len(fields[0]) != 1 || strings.IndexByte("+-rd", fields[0][0]) < 0 {
+		return nil, nil // Other events such as 't' carry nothing we measure
+	}
+	if !strings.Contains(fields[2], "/DeviceList/") {
+		return nil, nil // Queue disc and other non-device events
+	}
+	if len(fields) < 4 {
+		return nil, &ParseError{Field: -1, Text: line, Err: errFieldCount}
+	}
+
+	var err error
+	trace := &Trace{Event: Event(fields[0][0])}
+	if trace.Time, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return nil, &ParseError{Field: 1, Text: line, Err: err}
+	}
+	device, err := parseNs3Path(fields[2])
+	if err != nil {
+		return nil, &ParseError{Field: 2, Text: line, Err: err}
+	}
+
+	ip := ns3Header(fields[3], "ns3::Ipv4Header (")
+	if ip == nil {
+		return nil, nil // Not an IPv4 packet
+	}
+	key := ns3PacketKey{id: -1, protocol: -1}
+	for i := 0; i < len(ip); i++ {
+		switch ip[i] {
+		case "ECN":
+			if i+1 < len(ip) && strings.HasPrefix(ip[i+1], "ECT") {
+				trace.Flags |= FlagECT
+			} else if i+1 < len(ip) && ip[i+1] == "CE" {
+				trace.Flags |= FlagECT | FlagCE
+			}
+		case "id":
+			if i+1 < len(ip) {
+				key.id, err = strconv.Atoi(ip[i+1])
+			}
+		case "protocol":
+			if i+1 < len(ip) {
+				key.protocol, err = strconv.Atoi(ip[i+1])
+			}
+		case "length:":
+			if i+4 < len(ip) && ip[i+3] == ">" {
+				trace.Size, err = strconv.Atoi(ip[i+1])
+				key.src_ip = ip[i+2]
+				key.dst_ip = strings.TrimSuffix(ip[i+4], ")")
+			}
+		}
+		if err != nil {
+			return nil, &ParseError{Field: 3, Text: line, Err: err}
+		}
+	}
+	if key.id < 0 || key.protocol < 0 || key.src_ip == "" {
+		return nil, &ParseError{Field: 3, Text: line, Err: errors.New("incomplete Ipv4Header")}
+	}
+
+	var src_port, dst_port int
+	switch key.protocol {
+	case 6:
+		tcp := ns3Header(fields[3], "ns3::TcpHeader (")
+		if len(tcp) < 3 || tcp[1] != ">" {
+			return nil, &ParseError{Field: 3, Text: line, Err: errors.New("incomplete TcpHeader")}
+		}
+		src_port, err = strconv.Atoi(tcp[0])
+		if err == nil {
+			dst_port, err = strconv.Atoi(tcp[2])
+		}
+		for _, field := range tcp[3:] {
+			if err != nil {
+				break
+			}
+			if strings.HasPrefix(field, "Seq=") {
+				trace.Seq, err = strconv.Atoi(field[len("Seq="):])
+				continue
+			}
+			if strings.Contains(field, "ECE") {
+				trace.Flags |= FlagECNEcho
+			}
+			if strings.Contains(field, "CWR") {
+				trace.Flags |= FlagCWR
+			}
+		}
+		trace.Type = Ack
+		if ns3PayloadSize(fields[3]) > 0 {
+			trace.Type = TCP
+		}
+	case 17:
+		udp := ns3Header(fields[3], "ns3::UdpHeader (")
+		if len(udp) < 5 || udp[3] != ">" {
+			return nil, &ParseError{Field: 3, Text: line, Err: errors.New("incomplete UdpHeader")}
+		}
+		src_port, err = strconv.Atoi(udp[2])
+		if err == nil {
+			dst_port, err = strconv.Atoi(strings.TrimSuffix(udp[4], ")"))
+		}
+		trace.Type = UDP
+	default:
+		return nil, nil // Neither TCP nor UDP
+	}
+	if err != nil {
+		return nil, &ParseError{Field: 3, Text: line, Err: err}
+	}
+
+	// A packet enqueued again on the device it started from is a new packet
+	// that reuses the IP id after it wrapped around
+	packet, ok := p.packets[key]
+	if !ok || (trace.Event == Enqueue && packet.source == device) {
+		packet = ns3Packet{pid: p.next_pid, source: device}
+		p.packets[key] = packet
+		p.next_pid++
+		if _, ok := p.nodes[key.src_ip]; !ok {
+			p.nodes[key.src_ip] = device.Node
+		}
+	}
+	trace.Pid = packet.pid
+
+	// Forget a packet once it is dropped or delivered so that memory only
+	// grows with the packets in flight
+	if trace.Event == Drop {
+		delete(p.packets, key)
+	} else if node, ok := p.nodes[key.dst_ip]; ok && trace.Event == Receive && node == device.Node {
+		delete(p.packets, key)
+	}
+	trace.Fid = p.flows.fid(key.protocol, key.src_ip, src_port, key.dst_ip, dst_port)
+	trace.Src = Addr{Node: packet.source.Node, Port: src_port}
+	trace.Dst = Addr{Node: -1, Port: dst_port}
+	return &ns3Record{trace: trace, device: device, dst_ip: key.dst_ip}, nil
+}
+
+// Parse the node and device index out of an ns3 config path
+func parseNs3Path(path string) (Ns3Device, error) {
+	parts := strings.Split(path, "/")
+	device := Ns3Device{Node: -1, Device: -1}
+	for i := 0; i+1 < len(parts); i++ {
+		var err error
+		switch parts[i] {
+		case "NodeList":
+			device.Node, err = strconv.Atoi(parts[i+1])
+		case "DeviceList":
+			device.Device, err = strconv.Atoi(parts[i+1])
+		}
+		if err != nil {
+			return device, err
+		}
+	}
+	if device.Node < 0 || device.Device < 0 {
+		return device, errNs3Path
+	}
+	return device, nil
+}
+
+// Get the space separated fields of a header printed as "name (fields...)".
+// The fields run until the next header or the payload
+func ns3Header(packet string, name string) []string {
+	start := strings.Index(packet, name)
+	if start < 0 {
+		return nil
+	}
+	header := packet[start+len(name):]
+	if end := strings.Index(header, " ns3::"); end >= 0 {
+		header = header[:end]
+	}
+	if end := strings.Index(header, " Payload"); end >= 0 {
+		header = header[:end]
+	}
+	return strings.Fields(header)
+}
+
+// Get the payload size of an ns3 packet, or 0 if it has no payload
+func ns3PayloadSize(packet string) int {
+	start := strings.Index(packet, "Payload (size=")
+	if start < 0 {
+		return 0
+	}
+	size := packet[start+len("Payload (size="):]
+	if end := strings.IndexByte(size, ')'); end >= 0 {
+		size = size[:end]
+	}
+	n, _ := strconv.Atoi(size)
+	return n
+}
+
+// Create a TraceReader that reads ns3 ASCII trace lines from r. The topology
+// may be nil, in which case the far end of every link is reported as node -1
+func NewNs3TraceReader(r io.Reader, topology *Ns3Topology) *TraceReader {
+	reader := NewTraceReader(r)
+	reader.parse = newNs3Parser(topology).parseLine
+	return reader
+}
+
+// Open an ns3 ASCII trace file for streaming. The caller must Close the reader when done
+func OpenNs3TraceFile(file string, topology *Ns3Topology) (*TraceReader, error) {
+	reader, err := OpenTraceFile(file)
+	if err != nil {
+		return nil, err
+	}
+	reader.parse = newNs3Parser(topology).parseLine
+	return reader, nil
+}
+
+// The node that last received a packet and where the packet was headed
+type ns3Receive struct {
+	node   int
+	dst_ip string
+}
+
+// Discover the topology of an ns3 ASCII trace in a single pass. Links are
+// found by matching each dequeue with the receive of the same packet, and the
+// node that owns a destination address is the node that received a packet
+// for it without forwarding it any further
+func ScanNs3Topology(file string) (*Ns3Topology, error) {
+	reader, err := OpenTraceFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	topology := NewNs3Topology()
+	parser := newNs3Parser(topology)
+	dequeued := make(map[int]Ns3Device)  // A hashmap with {key, value} of {pid, device of event '-'}
+	received := make(map[int]ns3Receive) // A hashmap with {key, value} of {pid, last event 'r'}
+	reader.parse = func(line string) (*Trace, error) {
+		rec, err := parser.parseRecord(line)
+		if rec == nil || err != nil {
+			return nil, err
+		}
+		pid := rec.trace.Pid
+		switch rec.trace.Event {
+		case Enqueue:
+			delete(received, pid) // The packet is being forwarded
+		case Dequeue:
+			dequeued[pid] = rec.device
+		case Receive:
+			if device, ok := dequeued[pid]; ok {
+				topology.Peers[device] = rec.device
+				topology.Peers[rec.device] = device
+				delete(dequeued, pid)
+			}
+			received[pid] = ns3Receive{node: rec.device.Node, dst_ip: rec.dst_ip}
+		}
+		return rec.trace, nil
+	}
+	for reader.Next() {
+	}
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+
+	// A node that sent a packet owns its source address, and otherwise the
+	// node that kept a packet owns its destination address
+	for ip, node := range parser.nodes {
+		topology.Nodes[ip] = node
+	}
+	for _, recv := range received {
+		if _, ok := topology.Nodes[recv.dst_ip]; !ok {
+			topology.Nodes[recv.dst_ip] = recv.node
+		}
+	}
+	return topology, nil
+}
+
+// Parse an ns3 ASCII trace file and return a slice of Trace structs.
+// The topology is discovered with a first pass over the file
+func ParseNs3TraceFile(file string) ([]*Trace, error) {
+	topology, err := ScanNs3Topology(file)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := OpenNs3TraceFile(file, topology)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	var traces []*Trace
+	for reader.Next() {
+		traces = append(traces, reader.Trace())
+	}
+	return traces, reader.Err()
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A TCP segment from node 0 over node 1 to node 2 and its ack, a queue disc
+// event that is ignored, and a UDP packet from 10.1.3.1 dropped at node 1
+const ns3Trace = `+ 1 /NodeList/0/DeviceList/1/$ns3::PointToPointNetDevice/TxQueue/Enqueue ns3::PppHeader (Point-to-Point Protocol: IP (0x0021)) ns3::Ipv4Header (tos 0x0 DSCP Default ECN Not-ECT ttl 64 id 0 protocol 6 offset (bytes) 0 flags [none] length: 576 10.1.1.1 > 10.1.2.2) ns3::TcpHeader (49153 > 5000 [ACK] Seq=1 Ack=1 Win=65535 ns3::TcpOptionTS(1000;0)) Payload (size=536)
+- 1 /NodeList/0/DeviceList/1/$ns3::PointToPointNetDevice/TxQueue/Dequeue ns3::PppHeader (Point-to-Point Protocol: IP (0x0021)) ns3::Ipv4Header (tos 0x0 DSCP Default ECN Not-ECT ttl 64 id 0 protocol 6 offset (bytes) 0 flags [none] length: 576 10.1.1.1 > 10.1.2.2) ns3::TcpHeader (49153 > 5000 [ACK] Seq=1 Ack=1 Win=65535 ns3::TcpOptionTS(1000;0)) Payload (size=536)
+t 1 /NodeList/0/$ns3::TrafficControlLayer/RootQueueDiscList/1/Enqueue foo
+r 1.0026 /NodeList/1/DeviceList/1/$ns3::PointToPointNetDevice/MacRx ns3::Ipv4Header (tos 0x0 DSCP Default ECN Not-ECT ttl 64 id 0 protocol 6 offset (bytes) 0 flags [none] length: 576 10.1.1.1 > 10.1.2.2) ns3::TcpHeader (49153 > 5000 [ACK] Seq=1 Ack=1 Win=65535 ns3::TcpOptionTS(1000;0)) Payload (size=536)
++ 1.0026 /NodeList/1/DeviceList/2/$ns3::PointToPointNetDevice/TxQueue/Enqueue ns3::PppHeader (Point-to-Point Protocol: IP (0x0021)) ns3::Ipv4Header (tos 0x0 DSCP Default ECN ECT (0) ttl 63 id 0 protocol 6 offset (bytes) 0 flags [none] length: 576 10.1.1.1 > 10.1.2.2) ns3::TcpHeader (49153 > 5000 [ACK] Seq=1 Ack=1 Win=65535) Payload (size=536)
+- 1.0026 /NodeList/1/DeviceList/2/$ns3::PointToPointNetDevice/TxQueue/Dequeue ns3::PppHeader (Point-to-Point Protocol: IP (0x0021)) ns3::Ipv4Header (tos 0x0 DSCP Default ECN CE ttl 63 id 0 protocol 6 offset (bytes) 0 flags [none] length: 576 10.1.1.1 > 10.1.2.2) ns3::TcpHeader (49153 > 5000 [ACK] Seq=1 Ack=1 Win=65535) Payload (size=536)
+r 1.005 /NodeList/2/DeviceList/1/$ns3::PointToPointNetDevice/MacRx ns3::Ipv4Header (tos 0x0 DSCP Default ECN CE ttl 63 id 0 protocol 6 offset (bytes) 0 flags [none] length: 576 10.1.1.1 > 10.1.2.2) ns3::TcpHeader (49153 > 5000 [ACK] Seq=1 Ack=1 Win=65535) Payload (size=536)
++ 1.006 /NodeList/2/DeviceList/1/$ns3::PointToPointNetDevice/TxQueue/Enqueue ns3::PppHeader (Point-to-Point Protocol: IP (0x0021)) ns3::Ipv4Header (tos 0x0 DSCP Default ECN Not-ECT ttl 64 id 0 protocol 6 offset (bytes) 0 flags [none] length: 52 10.1.2.2 > 10.1.1.1) ns3::TcpHeader (5000 > 49153 [ACK|ECE] Seq=1 Ack=537 Win=65535)
++ 1.007 /NodeList/1/DeviceList/2/$ns3::PointToPointNetDevice/TxQueue/Enqueue ns3::PppHeader (Point-to-Point Protocol: IP (0x0021)) ns3::Ipv4Header (tos 0x0 DSCP Default ECN Not-ECT ttl 64 id 0 protocol 17 offset (bytes) 0 flags [none] length: 1052 10.1.3.1 > 10.1.2.2) ns3::UdpHeader (length: 1032 49153 > 9) Payload (size=1024)
+d 1.007 /NodeList/1/DeviceList/2/$ns3::PointToPointNetDevice/TxQueue/Drop ns3::PppHeader (Point-to-Point Protocol: IP (0x0021)) ns3::Ipv4Header (tos 0x0 DSCP Default ECN Not-ECT ttl 64 id 0 protocol 17 offset (bytes) 0 flags [none] length: 1052 10.1.3.1 > 10.1.2.2) ns3::UdpHeader (length: 1032 49153 > 9) Payload (size=1024)
+`
+
+// The same trace on the Trace model, with the links and the destination
+// nodes filled in from the discovered topology
+const ns3Want = `+ 1 0 1 tcp 576 ------- 1 0.49153 2.5000 1 0
+- 1 0 1 tcp 576 ------- 1 0.49153 2.5000 1 0
+r 1.0026 0 1 tcp 576 ------- 1 0.49153 2.5000 1 0
++ 1.0026 1 2 tcp 576 ------N 1 0.49153 2.5000 1 0
+- 1.0026 1 2 tcp 576 ----E-N 1 0.49153 2.5000 1 0
+r 1.005 1 2 tcp 576 ----E-N 1 0.49153 2.5000 1 0
++ 1.006 2 1 ack 52 C------ 1 2.5000 0.49153 1 1
++ 1.007 1 2 udp 1052 ------- 2 1.49153 2.9 0 2
+d 1.007 1 2 udp 1052 ------- 2 1.49153 2.9 0 2
+`
+
+func TestParseNs3TraceFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ns3.tr")
+	if err := os.WriteFile(file, []byte(ns3Trace), 0644); err != nil {
+		t.Fatal(err)
+	}
+	traces, err := ParseNs3TraceFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got strings.Builder
+	for _, trace := range traces {
+		got.WriteString(trace.String() + "\n")
+	}
+	if got.String() != ns3Want {
+		t.Errorf("got\n%s\nwant\n%s", got.String(), ns3Want)
+	}
+}
+
+func TestNs3TopologyIsShared(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ns3.tr")
+	if err := os.WriteFile(file, []byte(ns3Trace), 0644); err != nil {
+		t.Fatal(err)
+	}
+	topology, err := ScanNs3Topology(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave out the UDP source so that every reader has to learn it
+	delete(topology.Nodes, "10.1.3.1")
+	nodes := len(topology.Nodes)
+
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		reader := NewNs3TraceReader(strings.NewReader(ns3Trace), topology)
+		var out strings.Builder
+		for reader.Next() {
+			out.WriteString(reader.Trace().String() + "\n")
+		}
+		if err := reader.Err(); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, out.String())
+	}
+	if _, ok := topology.Nodes["10.1.3.1"]; ok || len(topology.Nodes) != nodes {
+		t.Errorf("reading changed the shared topology to %v", topology.Nodes)
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("two readers of one topology disagree\n%s\n%s", outputs[0], outputs[1])
+	}
+}
+
+func TestNs3UnknownNodes(t *testing.T) {
+	// Without a topology neither the far end of a link nor the destination is known
+	reader := NewNs3TraceReader(strings.NewReader(ns3Trace), nil)
+	if !reader.Next() {
+		t.Fatal(reader.Err())
+	}
+	want := "+ 1 0 -1 tcp 576 ------- 1 0.49153 -1.5000 1 0"
+	if got := reader.Trace().String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNs3ParseErrors(t *testing.T) {
+	first := strings.SplitN(ns3Trace, "\n", 2)[0]
+	tests := []struct {
+		line  string
+		field int
+	}{
+		{"+ 1", -1},
+		{"+ 1 /NodeList/0/DeviceList/1/Enqueue", -1},
+		{strings.Replace(first, "+ 1 ", "+ 1s ", 1), 1},
+		{strings.Replace(first, "/NodeList/0/", "/NodeList/zero/", 1), 2},
+		{strings.Replace(first, "id 0 protocol", "id zero protocol", 1), 3},
+		{strings.Replace(first, "(49153 > 5000", "(49153 5000", 1), 3},
+	}
+	for _, test := range tests {
+		reader := NewNs3TraceReader(strings.NewReader(test.line), nil)
+		if reader.Next() {
+			t.Errorf("%q: parsed as %v", test.line, reader.Trace())
+			continue
+		}
+		var perr *ParseError
+		if !errors.As(reader.Err(), &perr) || perr.Field != test.field {
+			t.Errorf("%q: err = %v, want a *ParseError at field %d", test.line, reader.Err(), test.field)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Write a trace of 'lines' lines that looks like a simulation02.tcl run and
+// return its path. Every 'bad' line number above 0 is replaced by a malformed line
+func writeTestTrace(tb testing.TB, lines int, trailing_newline bool, bad ...int) string {
+	tb.Helper()
+	events := []string{"+", "-", "r", "d"}
+	buf := make([]byte, 0, lines*64)
+	is_bad := make(map[int]bool)
+	for _, i := range bad {
+		is_bad[i] = true
+	}
+	for i := 1; i <= lines; i++ {
+		if is_bad[i] {
+			buf = append(buf, "r 1.5 1 2 tcp not-a-size ------- 1 0.0 3.0 7 9"...)
+		} else {
+			time := strconv.FormatFloat(float64(i)*0.0001, 'f', 6, 64) // Zero padded, so not canonical
+			fid := i%2 + 1
+			buf = append(buf, events[i%4]+" "+time+" 1 2 tcp 1040 -------"...)
+			buf = append(buf, " "+strconv.Itoa(fid)+" 0."+strconv.Itoa(fid)+" 3."+strconv.Itoa(fid)...)
+			buf = append(buf, " "+strconv.Itoa(i/4)+" "+strconv.Itoa(i)...)
+		}
+		if i < lines || trailing_newline {
+			buf = append(buf, '\n')
+		}
+	}
+	file := filepath.Join(tb.TempDir(), "test.tr")
+	if err := os.WriteFile(file, buf, 0644); err != nil {
+		tb.Fatal(err)
+	}
+	return file
+}
+
+func TestParseTraceFileParallelMatchesSequential(t *testing.T) {
+	for _, trailing_newline := range []bool{true, false} {
+		file := writeTestTrace(t, 10007, trailing_newline, 0)
+		want, err := ParseTraceFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{2, 3, 8, 64} {
+			got, err := ParseTraceFileParallel(file, workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("trailing newline %v, %d workers: %d traces, want %d", trailing_newline, workers, len(got), len(want))
+			}
+			for i := range want {
+				if got[i].String() != want[i].String() {
+					t.Fatalf("trailing newline %v, %d workers: trace %d = %q, want %q", trailing_newline, workers, i, got[i], want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestParseTraceFileParallelErrorLine(t *testing.T) {
+	for _, trailing_newline := range []bool{true, false} {
+		for _, bad := range []int{1, 2500, 5003, 10007} {
+			file := writeTestTrace(t, 10007, trailing_newline, bad)
+			_, want := ParseTraceFile(file)
+			if want == nil {
+				t.Fatalf("line %d: sequential parser accepted a malformed line", bad)
+			}
+			for _, workers := range []int{2, 8} {
+				_, got := ParseTraceFileParallel(file, workers)
+				if got == nil || got.Error() != want.Error() {
+					t.Errorf("trailing newline %v, line %d, %d workers: error %v, want %v", trailing_newline, bad, workers, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestParseTraceFileParallelLenient(t *testing.T) {
+	bad := []int{1, 2500, 5003, 5004, 10007}
+	for _, trailing_newline := range []bool{true, false} {
+		file := writeTestTrace(t, 10007, trailing_newline, bad...)
+		want, skipped, err := ParseTraceFileLenient(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{1, 2, 8} {
+			got, errs, err := ParseTraceFileParallelLenient(file, workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) || len(errs) != skipped {
+				t.Fatalf("trailing newline %v, %d workers: %d traces and %d errors, want %d and %d", trailing_newline, workers, len(got), len(errs), len(want), skipped)
+			}
+			for i := range want {
+				if got[i].String() != want[i].String() {
+					t.Fatalf("trailing newline %v, %d workers: trace %d = %q, want %q", trailing_newline, workers, i, got[i], want[i])
+				}
+			}
+			// Each skipped line reports the error a Strict parse stops at
+			for i, line := range bad {
+				_, err := ParseTraceFile(writeTestTrace(t, 10007, trailing_newline, line))
+				strict, ok := err.(*ParseError)
+				if !ok {
+					t.Fatalf("line %d: strict error %v is not a *ParseError", line, err)
+				}
+				strict.File = file
+				if errs[i].Error() != strict.Error() {
+					t.Errorf("trailing newline %v, %d workers: error %d = %v, want %v", trailing_newline, workers, i, errs[i], strict)
+				}
+			}
+		}
+	}
+}
+
+// About 8 MB of trace
+const benchmarkTraceLines = 150000
+
+func BenchmarkParseTraceFile(b *testing.B) {
+	file := writeTestTrace(b, benchmarkTraceLines, true, 0)
+	info, _ := os.Stat(file)
+	b.SetBytes(info.Size())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseTraceFile(file); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Run with fixed worker counts, since 0 workers on a single CPU falls back to ParseTraceFile
+func BenchmarkParseTraceFileParallel(b *testing.B) {
+	file := writeTestTrace(b, benchmarkTraceLines, true, 0)
+	info, _ := os.Stat(file)
+	for _, workers := range []int{2, 4} {
+		b.Run(strconv.Itoa(workers)+"workers", func(b *testing.B) {
+			b.SetBytes(info.Size())
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseTraceFileParallel(file, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,469 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+)
+
+// Link layer types of the packets in a capture
+const (
+	linkTypeNull     = 0   // BSD loopback, a 4 byte address family
+	linkTypeEthernet = 1   // Ethernet II, optionally with VLAN tags
+	linkTypeRaw      = 101 // Raw IPv4 or IPv6
+	linkTypeLinuxSLL = 113 // Linux cooked capture
+	linkTypeIPv4     = 228 // Raw IPv4
+	linkTypeIPv6     = 229 // Raw IPv6
+)
+
+// pcapng block types
+const (
+	pcapngSectionBlock   = 0x0A0D0D0A
+	pcapngInterfaceBlock = 0x00000001
+	pcapngPacketBlock    = 0x00000002 // Obsolete, but still written by some tools
+	pcapngEnhancedBlock  = 0x00000006
+	pcapngByteOrderMagic = 0x1A2B3C4D
+)
+
+var errPcapFormat = errors.New("not a pcap or pcapng file")
+
+// A pcapng interface, which decides the link type and timestamp units of its packets
+type pcapngInterface struct {
+	link_type uint32
+	ts_units  float64 // Timestamp units per second
+}
+
+// PcapReader streams traces from a pcap or pcapng packet capture. Every IPv4
+// or IPv6 TCP and UDP packet becomes a receive event on the link the capture
+// was taken on. Other packets are skipped
+type PcapReader struct {
+	reader *bufio.Reader
+	closer io.Closer
+	from   int // The node at the start of the captured link
+	to     int // The node at the end of the captured link
+	flows  *FlowTable
+
+	ng        bool
+	order     binary.ByteOrder
+	link_type uint32            // pcap only
+	ts_units  float64           // pcap only
+	ifaces    []pcapngInterface // pcapng only
+
+	skipped int
+	time    float64 // The timestamp of the packet being decoded
+	trace   *Trace
+	err     error
+}
+
+// Create a PcapReader for a capture taken on the link 'from' -> 'to'. Readers
+// of several captures should share one FlowTable so their fids and pids agree.
+// A nil FlowTable creates a new one
+func NewPcapReader(r io.Reader, from int, to int, flows *FlowTable) (*PcapReader, error) {
+	if flows == nil {
+		flows = NewFlowTable()
+	}
+	reader := &PcapReader{reader: bufio.NewReader(r), from: from, to: to, flows: flows}
+	magic, err := reader.reader.Peek(4)
+	if err != nil {
+		return nil, errPcapFormat
+	}
+	if binary.LittleEndian.Uint32(magic) == pcapngSectionBlock {
+		reader.ng = true
+		return reader, nil
+	}
+
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(reader.reader, header); err != nil {
+		return nil, errPcapFormat
+	}
+	switch {
+	case binary.LittleEndian.Uint32(header) == 0xa1b2c3d4:
+		reader.order, reader.ts_units = binary.LittleEndian, 1e6
+	case binary.BigEndian.Uint32(header) == 0xa1b2c3d4:
+		reader.order, reader.ts_units = binary.BigEndian, 1e6
+	case binary.LittleEndian.Uint32(header) == 0xa1b23c4d:
+		reader.order, reader.ts_units = binary.LittleEndian, 1e9
+	case binary.BigEndian.Uint32(header) == 0xa1b23c4d:
+		reader.order, reader.ts_units = binary.BigEndian, 1e9
+	default:
+		return nil, errPcapFormat
+	}
+	reader.link_type = reader.order.Uint32(header[20:24]) & 0xffff
+	return reader, nil
+}
+
+// Open a capture file for streaming. gzip and bzip2 compressed files are
+// decompressed on the fly. The caller must Close the reader when done
+func OpenPcapFile(file string, from int, to int, flows *FlowTable) (*PcapReader, error) {
+	f, err := openDecompressed(file)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := NewPcapReader(f, from, to, flows)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	reader.closer = f
+	return reader, nil
+}
+
+// Advance to the next TCP or UDP packet. Return false at the end of input or on error
+func (r *PcapReader) Next() bool {
+	for r.err == nil {
+		var time float64
+		var link_type uint32
+		var data []byte
+		var err error
+		if r.ng {
+			time, link_type, data, err = r.readBlock()
+		} else {
+			time, link_type, data, err = r.readRecord()
+		}
+		if err != nil {
+			if err != io.EOF {
+				r.err = err
+			}
+			return false
+		}
+		if data == nil {
+			continue // A block without a packet
+		}
+		r.time = time
+		trace := r.decode(link_type, data)
+		if trace == nil {
+			r.skipped++
+			continue
+		}
+		r.trace = trace
+		return true
+	}
+	return false
+}
+
+// Get the trace read by the last call to Next
+func (r *PcapReader) Trace() *Trace {
+	return r.trace
+}
+
+// Get the number of packets skipped because they were not IP TCP or UDP
+func (r *PcapReader) Skipped() int {
+	return r.skipped
+}
+
+// Get the first error encountered while reading, if any
+func (r *PcapReader) Err() error {
+	return r.err
+}
+
+// Close the underlying file if the reader was opened with OpenPcapFile
+func (r *PcapReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Read the next pcap record
+func (r *PcapReader) readRecord() (float64, uint32, []byte, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, 0, nil, errors.New("truncated pcap record header")
+		}
+		return 0, 0, nil, err
+	}
+	seconds := r.order.Uint32(header[0:4])
+	fraction := r.order.Uint32(header[4:8])
+	cap_len := r.order.Uint32(header[8:12])
+	data, err := r.readBytes(cap_len)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return float64(seconds) + float64(fraction)/r.ts_units, r.link_type, data, nil
+}
+
+// Read the next pcapng block. Blocks that hold no packet return nil data
+func (r *PcapReader) readBlock() (float64, uint32, []byte, error) {
+	header, err := r.reader.Peek(8)
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return 0, 0, nil, io.EOF
+		}
+		return 0, 0, nil, errors.New("truncated pcapng block header")
+	}
+
+	// The section header decides the byte order of every block that follows
+	if binary.LittleEndian.Uint32(header) == pcapngSectionBlock {
+		shb, err := r.reader.Peek(12)
+		if err != nil {
+			return 0, 0, nil, errors.New("truncated pcapng section header")
+		}
+		switch {
+		case binary.LittleEndian.Uint32(shb[8:12]) == pcapngByteOrderMagic:
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(shb[8:12]) == pcapngByteOrderMagic:
+			r.order = binary.BigEndian
+		default:
+			return 0, 0, nil, errPcapFormat
+		}
+		r.ifaces = r.ifaces[:0] // Interfaces are numbered per section
+	}
+
+	block_type := r.order.Uint32(header[0:4])
+	block_len := r.order.Uint32(header[4:8])
+	if block_len < 12 || block_len%4 != 0 {
+		return 0, 0, nil, fmt.Errorf("invalid pcapng block length %d", block_len)
+	}
+	block, err := r.readBytes(block_len)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	body := block[8 : block_len-4]
+
+	switch block_type {
+	case pcapngInterfaceBlock:
+		if len(body) < 8 {
+			return 0, 0, nil, errors.New("truncated pcapng interface block")
+		}
+		iface := pcapngInterface{link_type: uint32(r.order.Uint16(body[0:2])), ts_units: 1e6}
+		r.parseInterfaceOptions(&iface, body[8:])
+		r.ifaces = append(r.ifaces, iface)
+	case pcapngEnhancedBlock:
+		if len(body) < 20 {
+			return 0, 0, nil, errors.New("truncated pcapng packet block")
+		}
+		iface_id := r.order.Uint32(body[0:4])
+		timestamp := uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+		cap_len := r.order.Uint32(body[12:16])
+		return r.packetBlock(iface_id, timestamp, cap_len, body[20:])
+	case pcapngPacketBlock:
+		if len(body) < 20 {
+			return 0, 0, nil, errors.New("truncated pcapng packet block")
+		}
+		iface_id := uint32(r.order.Uint16(body[0:2]))
+		timestamp := uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+		cap_len := r.order.Uint32(body[12:16])
+		return r.packetBlock(iface_id, timestamp, cap_len, body[20:])
+	}
+	return 0, 0, nil, nil
+}
+
+// Get the time, link type and data of a pcapng packet block
+func (r *PcapReader) packetBlock(iface_id uint32, timestamp uint64, cap_len uint32, data []byte) (float64, uint32, []byte, error) {
+	if int(iface_id) >= len(r.ifaces) {
+		return 0, 0, nil, fmt.Errorf("pcapng packet on unknown interface %d", iface_id)
+	}
+	if int(cap_len) > len(data) {
+		return 0, 0, nil, errors.New("truncated pcapng packet data")
+	}
+	iface := r.ifaces[iface_id]
+	return float64(timestamp) / iface.ts_units, iface.link_type, data[:cap_len], nil
+}
+
+// Read the if_tsresol option of a pcapng interface block
+func (r *PcapReader) parseInterfaceOptions(iface *pcapngInterface, options []byte) {
+	for len(options) >= 4 {
+		code := r.order.Uint16(options[0:2])
+		length := int(r.order.Uint16(options[2:4]))
+		if code == 0 || 4+length > len(options) {
+			return
+		}
+		if code == 9 && length >= 1 {
+			resolution := options[4]
+			if resolution&0x80 == 0 {
+				iface.ts_units = math.Pow(10, float64(resolution))
+			} else {
+				iface.ts_units = math.Pow(2, float64(resolution&0x7f))
+			}
+		}
+		options = options[4+(length+3)&^3:]
+	}
+}
+
+// Read exactly n bytes
+func (r *PcapReader) readBytes(n uint32) ([]byte, error) {
+	if n > 1<<26 {
+		return nil, fmt.Errorf("record length %d is too large", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, errors.New("truncated capture")
+	}
+	return data, nil
+}
+
+// Decode the link layer of a packet down to its IP header
+func (r *PcapReader) decode(link_type uint32, data []byte) *Trace {
+	switch link_type {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil
+		}
+		ether_type := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		for (ether_type == 0x8100 || ether_type == 0x88a8) && len(data) >= 4 {
+			ether_type = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		switch ether_type {
+		case 0x0800:
+			return r.decodeIPv4(data)
+		case 0x86DD:
+			return r.decodeIPv6(data)
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil
+		}
+		switch binary.BigEndian.Uint16(data[14:16]) {
+		case 0x0800:
+			return r.decodeIPv4(data[16:])
+		case 0x86DD:
+			return r.decodeIPv6(data[16:])
+		}
+	case linkTypeNull:
+		if len(data) < 4 {
+			return nil
+		}
+		return r.decodeIP(data[4:])
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+		return r.decodeIP(data)
+	}
+	return nil
+}
+
+// Decode an IP packet of either version
+func (r *PcapReader) decodeIP(data []byte) *Trace {
+	if len(data) == 0 {
+		return nil
+	}
+	switch data[0] >> 4 {
+	case 4:
+		return r.decodeIPv4(data)
+	case 6:
+		return r.decodeIPv6(data)
+	}
+	return nil
+}
+
+// Decode an IPv4 header and its transport header
+func (r *PcapReader) decodeIPv4(data []byte) *Trace {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return nil
+	}
+	header_len := int(data[0]&0x0f) * 4
+	total_len := int(binary.BigEndian.Uint16(data[2:4]))
+	if header_len < 20 || len(data) < header_len || total_len < header_len {
+		return nil
+	}
+	if binary.BigEndian.Uint16(data[6:8])&0x1fff != 0 {
+		return nil // Only the first fragment carries the transport header
+	}
+	packet := packetKey{
+		protocol: int(data[9]),
+		src_ip:   net.IP(data[12:16]).String(),
+		dst_ip:   net.IP(data[16:20]).String(),
+		ip_id:    int(binary.BigEndian.Uint16(data[4:6])),
+	}
+	return r.decodeTransport(packet, data[1]&0x03, total_len, total_len-header_len, data[header_len:])
+}
+
+// Decode an IPv6 header, its extension headers and its transport header
+func (r *PcapReader) decodeIPv6(data []byte) *Trace {
+	if len(data) < 40 || data[0]>>4 != 6 {
+		return nil
+	}
+	payload_len := int(binary.BigEndian.Uint16(data[4:6]))
+	traffic_class := (data[0]&0x0f)<<4 | data[1]>>4
+	packet := packetKey{
+		protocol: int(data[6]),
+		src_ip:   net.IP(data[8:24]).String(),
+		dst_ip:   net.IP(data[24:40]).String(),
+		ip_id:    -1,
+	}
+	data = data[40:]
+	remaining := payload_len
+	for {
+		switch packet.protocol {
+		case 0, 43, 60: // Hop-by-hop, routing and destination options
+			if len(data) < 8 {
+				return nil
+			}
+			ext_len := (int(data[1]) + 1) * 8
+			if len(data) < ext_len {
+				return nil
+			}
+			packet.protocol = int(data[0])
+			data, remaining = data[ext_len:], remaining-ext_len
+			continue
+		case 44: // Fragment
+			if len(data) < 8 || binary.BigEndian.Uint16(data[2:4])&0xfff8 != 0 {
+				return nil
+			}
+			packet.protocol = int(data[0])
+			data, remaining = data[8:], remaining-8
+			continue
+		}
+		break
+	}
+	return r.decodeTransport(packet, traffic_class&0x03, 40+payload_len, remaining, data)
+}
+
+// Decode a TCP or UDP header into a Trace. 'ecn' is the ECN field of the IP
+// header, 'size' the IP packet size and 'transport_len' the IP payload size
+func (r *PcapReader) decodeTransport(packet packetKey, ecn byte, size int, transport_len int, data []byte) *Trace {
+	trace := &Trace{Event: Receive, Time: r.time, From: r.from, To: r.to, Size: size}
+	if ecn != 0 {
+		trace.Flags |= FlagECT
+	}
+	if ecn == 0x03 {
+		trace.Flags |= FlagCE
+	}
+
+	switch packet.protocol {
+	case 6:
+		if len(data) < 20 {
+			return nil
+		}
+		header_len := int(data[12]>>4) * 4
+		packet.src_port = int(binary.BigEndian.Uint16(data[0:2]))
+		packet.dst_port = int(binary.BigEndian.Uint16(data[2:4]))
+		packet.seq = int(binary.BigEndian.Uint32(data[4:8]))
+		packet.ack = int(binary.BigEndian.Uint32(data[8:12]))
+		packet.checksum = int(binary.BigEndian.Uint16(data[16:18]))
+		packet.length = transport_len - header_len
+		if data[13]&0x40 != 0 {
+			trace.Flags |= FlagECNEcho
+		}
+		if data[13]&0x80 != 0 {
+			trace.Flags |= FlagCWR
+		}
+		trace.Seq = packet.seq
+		trace.Type = Ack
+		if packet.length > 0 {
+			trace.Type = TCP
+		}
+	case 17:
+		if len(data) < 8 {
+			return nil
+		}
+		packet.src_port = int(binary.BigEndian.Uint16(data[0:2]))
+		packet.dst_port = int(binary.BigEndian.Uint16(data[2:4]))
+		packet.checksum = int(binary.BigEndian.Uint16(data[6:8]))
+		packet.length = transport_len - 8
+		trace.Type = UDP
+	default:
+		return nil
+	}
+
+	trace.Fid = r.flows.fid(packet.protocol, packet.src_ip, packet.src_port, packet.dst_ip, packet.dst_port)
+	trace.Pid = r.flows.pid(packet, r.time)
+	trace.Src = r.flows.addr(packet.src_ip, packet.src_port)
+	trace.Dst = r.flows.addr(packet.dst_ip, packet.dst_port)
+	return trace
+}
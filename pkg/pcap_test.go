@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Build an IPv4 packet from 10.0.0.1 to 10.0.0.2, or back if 'reply' is set,
+// around a transport header and 'payload' bytes of data
+func ipv4Packet(protocol byte, reply bool, transport []byte, payload int) []byte {
+	packet := make([]byte, 20, 20+len(transport)+payload)
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(20+len(transport)+payload))
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(transport)+payload)) // Any id will do
+	packet[8] = 64
+	packet[9] = protocol
+	src, dst := []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}
+	if reply {
+		src, dst = dst, src
+	}
+	copy(packet[12:16], src)
+	copy(packet[16:20], dst)
+	packet = append(packet, transport...)
+	return append(packet, make([]byte, payload)...)
+}
+
+// Build a TCP header without options
+func tcpHeader(src_port uint16, dst_port uint16, seq uint32, ack uint32) []byte {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:2], src_port)
+	binary.BigEndian.PutUint16(header[2:4], dst_port)
+	binary.BigEndian.PutUint32(header[4:8], seq)
+	binary.BigEndian.PutUint32(header[8:12], ack)
+	header[12] = 5 << 4
+	header[13] = 0x10 // ACK
+	return header
+}
+
+// Build a UDP header
+func udpHeader(src_port uint16, dst_port uint16, payload int) []byte {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint16(header[0:2], src_port)
+	binary.BigEndian.PutUint16(header[2:4], dst_port)
+	binary.BigEndian.PutUint16(header[4:6], uint16(8+payload))
+	return header
+}
+
+// Wrap an IP packet in an Ethernet II frame of 'ether_type'
+func ethernetFrame(ether_type uint16, packet []byte) []byte {
+	frame := make([]byte, 14, 14+len(packet))
+	binary.BigEndian.PutUint16(frame[12:14], ether_type)
+	return append(frame, packet...)
+}
+
+// The packets of every test capture: a TCP segment, its ACK and a UDP
+// datagram, plus an ARP frame that is not IP and must be skipped
+var (
+	pcapData = ipv4Packet(6, false, tcpHeader(5000, 80, 1000, 1), 100)
+	pcapAck  = ipv4Packet(6, true, tcpHeader(80, 5000, 7, 1100), 0)
+	pcapUDP  = ipv4Packet(17, false, udpHeader(6000, 9, 50), 50)
+	pcapARP  = make([]byte, 28)
+)
+
+// The captured packets seen on the link 0 -> 1, with 10.0.0.1 on node 0 and 10.0.0.2 on node 3
+const pcapWant = `r 1.5 0 1 tcp 140 ------- 1 0.5000 3.80 1000 0
+r 1.75 0 1 ack 40 ------- 1 3.80 0.5000 7 1
+r 2 0 1 udp 78 ------- 2 0.6000 3.9 0 2
+`
+
+// Build a classic pcap capture of Ethernet frames with either timestamp magic
+func classicPcap(order binary.ByteOrder, nanoseconds bool) []byte {
+	var buf bytes.Buffer
+	magic, units := uint32(0xa1b2c3d4), 1e6
+	if nanoseconds {
+		magic, units = 0xa1b23c4d, 1e9
+	}
+	binary.Write(&buf, order, magic)
+	binary.Write(&buf, order, []uint16{2, 4})
+	binary.Write(&buf, order, []uint32{0, 0, 65535, linkTypeEthernet})
+	frames := [][]byte{
+		ethernetFrame(0x0800, pcapData),
+		ethernetFrame(0x0800, pcapAck),
+		ethernetFrame(0x0806, pcapARP),
+		ethernetFrame(0x0800, pcapUDP),
+	}
+	times := []float64{1.5, 1.75, 1.9, 2}
+	for i, frame := range frames {
+		seconds := uint32(times[i])
+		fraction := uint32((times[i]-float64(seconds))*units + 0.5)
+		binary.Write(&buf, order, []uint32{seconds, fraction, uint32(len(frame)), uint32(len(frame))})
+		buf.Write(frame)
+	}
+	return buf.Bytes()
+}
+
+// Append a pcapng block of 'block_type' with 'body' padded to 32 bits
+func appendBlock(buf *bytes.Buffer, order binary.ByteOrder, block_type uint32, body []byte) {
+	padded := (len(body) + 3) &^ 3
+	length := uint32(12 + padded)
+	binary.Write(buf, order, []uint32{block_type, length})
+	buf.Write(body)
+	buf.Write(make([]byte, padded-len(body)))
+	binary.Write(buf, order, length)
+}
+
+// Build a pcapng capture with an Ethernet interface in microseconds and a raw
+// IPv4 interface in nanoseconds, whose packets are interleaved
+func pcapngCapture(order binary.ByteOrder) []byte {
+	var buf bytes.Buffer
+	var body bytes.Buffer
+	binary.Write(&body, order, []uint32{pcapngByteOrderMagic, 1, 0xffffffff, 0xffffffff})
+	appendBlock(&buf, order, pcapngSectionBlock, body.Bytes())
+
+	body.Reset()
+	binary.Write(&body, order, []uint16{linkTypeEthernet, 0})
+	binary.Write(&body, order, uint32(65535))
+	appendBlock(&buf, order, pcapngInterfaceBlock, body.Bytes())
+
+	body.Reset()
+	binary.Write(&body, order, []uint16{linkTypeRaw, 0})
+	binary.Write(&body, order, uint32(65535))
+	binary.Write(&body, order, []uint16{9, 1}) // if_tsresol of 10^-9
+	body.Write([]byte{9, 0, 0, 0})
+	binary.Write(&body, order, []uint16{0, 0})
+	appendBlock(&buf, order, pcapngInterfaceBlock, body.Bytes())
+
+	packets := []struct {
+		iface uint32
+		units float64
+		data  []byte
+		time  float64
+	}{
+		{0, 1e6, ethernetFrame(0x0800, pcapData), 1.5},
+		{1, 1e9, pcapAck, 1.75},
+		{0, 1e6, ethernetFrame(0x0806, pcapARP), 1.9},
+		{1, 1e9, pcapUDP, 2},
+	}
+	for _, packet := range packets {
+		timestamp := uint64(packet.time*packet.units + 0.5)
+		body.Reset()
+		binary.Write(&body, order, []uint32{packet.iface, uint32(timestamp >> 32), uint32(timestamp),
+			uint32(len(packet.data)), uint32(len(packet.data))})
+		body.Write(packet.data)
+		appendBlock(&buf, order, pcapngEnhancedBlock, body.Bytes())
+	}
+	return buf.Bytes()
+}
+
+// Read every trace of a capture on the link 0 -> 1
+func readPcap(capture []byte) (string, int, error) {
+	flows := NewFlowTable()
+	flows.Nodes["10.0.0.1"] = 0
+	flows.Nodes["10.0.0.2"] = 3
+	reader, err := NewPcapReader(bytes.NewReader(capture), 0, 1, flows)
+	if err != nil {
+		return "", 0, err
+	}
+	var out strings.Builder
+	for reader.Next() {
+		out.WriteString(reader.Trace().String() + "\n")
+	}
+	return out.String(), reader.Skipped(), reader.Err()
+}
+
+// Get every capture format the reader accepts
+func pcapCaptures() map[string][]byte {
+	return map[string][]byte{
+		"pcap little endian us": classicPcap(binary.LittleEndian, false),
+		"pcap big endian us":    classicPcap(binary.BigEndian, false),
+		"pcap little endian ns": classicPcap(binary.LittleEndian, true),
+		"pcap big endian ns":    classicPcap(binary.BigEndian, true),
+		"pcapng little endian":  pcapngCapture(binary.LittleEndian),
+		"pcapng big endian":     pcapngCapture(binary.BigEndian),
+	}
+}
+
+func TestPcapReader(t *testing.T) {
+	for name, capture := range pcapCaptures() {
+		got, skipped, err := readPcap(capture)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got != pcapWant {
+			t.Errorf("%s: got\n%s\nwant\n%s", name, got, pcapWant)
+		}
+		if skipped != 1 {
+			t.Errorf("%s: skipped %d packets, want the ARP frame only", name, skipped)
+		}
+	}
+	if _, _, err := readPcap([]byte("r 1.0 0 1 tcp 1040 ------- 1 0.0 3.0 0 0\n")); err != errPcapFormat {
+		t.Errorf("ns2 trace: err = %v, want %v", err, errPcapFormat)
+	}
+}
+
+func TestPcapReaderTruncated(t *testing.T) {
+	for name, capture := range pcapCaptures() {
+		full, _, _ := readPcap(capture)
+		for cut := 0; cut < len(capture); cut++ {
+			got, err := readTruncatedPcap(capture[:cut])
+			if err != nil {
+				continue
+			}
+			// Only a cut between two records or blocks reads cleanly, and then
+			// it reads a prefix of the full capture
+			if !strings.HasPrefix(full, got) || !recordBoundary(capture, cut) {
+				t.Errorf("%s cut at %d: read %q without an error", name, cut, got)
+			}
+		}
+	}
+}
+
+// Read a truncated capture, turning a panic into an error of the test
+func readTruncatedPcap(capture []byte) (got string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = nil
+			got = fmt.Sprintf("panic: %v", r)
+		}
+	}()
+	got, _, err = readPcap(capture)
+	return got, err
+}
+
+// Report whether 'cut' falls between two records of a classic pcap capture or
+// two blocks of a pcapng capture
+func recordBoundary(capture []byte, cut int) bool {
+	order := binary.ByteOrder(binary.LittleEndian)
+	if binary.LittleEndian.Uint32(capture) == pcapngSectionBlock {
+		if binary.BigEndian.Uint32(capture[8:12]) == pcapngByteOrderMagic {
+			order = binary.BigEndian
+		}
+		for offset := 0; offset < len(capture); offset += int(order.Uint32(capture[offset+4:])) {
+			if offset == cut {
+				return true
+			}
+		}
+		return false
+	}
+	if binary.BigEndian.Uint32(capture)&0xffff0000 == 0xa1b20000 {
+		order = binary.BigEndian
+	}
+	for offset := 24; offset < len(capture); offset += 16 + int(order.Uint32(capture[offset+8:])) {
+		if offset == cut {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package pkg
+
+import "math"
+
+// QueueMonitor reconstructs the queue of a link in a single pass over a
+// trace. A packet joins the queue on '+' and leaves it on '-' or 'd', so the
+// packet being transmitted is not counted, the same as ns2's queue monitors.
+// Feed it every trace of the link, not just a single flow
+type QueueMonitor struct {
+	link Link
+	span TimeSpan
+
+	queued  map[int]int // A hashmap with {key, value} of {pid, packet size}
+	packets int
+	bytes   int
+
+	peak_packets int
+	peak_bytes   int
+
+	last_time   float64 // Time of the last event, or NaN before the first one
+	first_time  float64 // Start of the measured period, the first event or the span start
+	packet_area float64 // The integral of the packet occupancy over time
+	byte_area   float64 // The integral of the byte occupancy over time
+
+	time_ticks   []float64
+	packet_ticks []float64
+	byte_ticks   []float64
+}
+
+// Create a QueueMonitor for the link 'from_node' -> 'to_node' that only
+// measures the queue inside 'span'
+func NewQueueMonitor(from_node int, to_node int, span TimeSpan) *QueueMonitor {
+	return &QueueMonitor{
+		link:       Link{From: from_node, To: to_node},
+		span:       span,
+		queued:     make(map[int]int),
+		last_time:  math.NaN(),
+		first_time: math.NaN(),
+	}
+}
+
+// Add the next trace. Traces must arrive in time order
+func (m *QueueMonitor) Add(trace *Trace) {
+	if trace.From != m.link.From || trace.To != m.link.To {
+		return
+	}
+	switch trace.Event {
+	case Enqueue:
+		m.advance(trace.Time)
+		m.queued[trace.Pid] = trace.Size
+		m.packets++
+		m.bytes += trace.Size
+	case Dequeue, Drop:
+		size, ok := m.queued[trace.Pid]
+		if !ok {
+			return // The packet was queued before the trace started
+		}
+		m.advance(trace.Time)
+		delete(m.queued, trace.Pid)
+		m.packets--
+		m.bytes -= size
+	default:
+		return
+	}
+	if m.span.Contains(trace.Time) {
+		if m.packets > m.peak_packets {
+			m.peak_packets = m.packets
+		}
+		if m.bytes > m.peak_bytes {
+			m.peak_bytes = m.bytes
+		}
+		m.time_ticks = append(m.time_ticks, trace.Time)
+		m.packet_ticks = append(m.packet_ticks, float64(m.packets))
+		m.byte_ticks = append(m.byte_ticks, float64(m.bytes))
+	}
+}
+
+// Integrate the occupancy from the last event up to 'time', clipped to the span
+func (m *QueueMonitor) advance(time float64) {
+	if math.IsNaN(m.last_time) {
+		m.first_time = math.Max(time, m.span.Start)
+	} else {
+		start := math.Max(m.last_time, m.span.Start)
+		end := math.Min(time, m.span.End)
+		if end > start {
+			m.packet_area += float64(m.packets) * (end - start)
+			m.byte_area += float64(m.bytes) * (end - start)
+		}
+	}
+	m.last_time = time
+}
+
+// Get the time-averaged occupancy in packets and in bytes
+func (m *QueueMonitor) Average() (float64, float64) {
+	if math.IsNaN(m.first_time) {
+		return 0, 0
+	}
+	duration := math.Min(m.last_time, m.span.End) - m.first_time
+	if duration <= 0 {
+		return 0, 0
+	}
+	return m.packet_area / duration, m.byte_area / duration
+}
+
+// Get the peak occupancy in packets and in bytes
+func (m *QueueMonitor) Peak() (int, int) {
+	return m.peak_packets, m.peak_bytes
+}
+
+// Return slice times, slice queue lengths in packets, and time-averaged queue length in packets
+func (m *QueueMonitor) Result() ([]float64, []float64, float64) {
+	avg_packets, _ := m.Average()
+	return m.time_ticks, m.packet_ticks, avg_packets
+}
+
+// Return slice times, slice queue lengths in bytes, and time-averaged queue length in bytes
+func (m *QueueMonitor) Bytes() ([]float64, []float64, float64) {
+	_, avg_bytes := m.Average()
+	return m.time_ticks, m.byte_ticks, avg_bytes
+}
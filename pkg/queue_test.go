@@ -0,0 +1,55 @@
+package pkg
+
+import (
+	"math"
+	"testing"
+)
+
+// The queue of link 1 -> 2: packet 1 is sent, packet 2 is dropped and packet 3
+// is sent after it. The traces on link 0 -> 1 and the dequeue of packet 9,
+// queued before the trace started, must not count
+const queueTrace = `+ 0 1 2 tcp 1000 ------- 1 0.0 3.0 1 1
++ 0.5 0 1 tcp 1000 ------- 1 0.0 3.0 4 4
++ 1 1 2 cbr 500 ------- 2 1.0 2.0 2 2
+- 1.5 1 2 tcp 1000 ------- 1 0.0 3.0 9 9
+- 2 1 2 tcp 1000 ------- 1 0.0 3.0 1 1
+d 3 1 2 cbr 500 ------- 2 1.0 2.0 2 2
++ 3 1 2 tcp 1000 ------- 1 0.0 3.0 3 3
+- 4 1 2 tcp 1000 ------- 1 0.0 3.0 3 3
+`
+
+func TestQueueMonitor(t *testing.T) {
+	monitor := NewQueueMonitor(1, 2, FullSpan())
+	for _, trace := range parseTestTraces(t, queueTrace) {
+		monitor.Add(trace)
+	}
+	times, packets, avg_packets := monitor.Result()
+	checkSeries(t, "packets", times, packets, []float64{0, 1, 2, 3, 3, 4}, []float64{1, 2, 1, 0, 1, 0})
+	_, bytes, avg_bytes := monitor.Bytes()
+	checkSeries(t, "bytes", times, bytes, []float64{0, 1, 2, 3, 3, 4}, []float64{1000, 1500, 500, 0, 1000, 0})
+
+	// 1 packet for a second, 2 for a second, 1 for a second, then 1 for a second over 4 seconds
+	if math.Abs(avg_packets-1.25) > 1e-9 || math.Abs(avg_bytes-1000) > 1e-9 {
+		t.Errorf("average = %g packets and %g bytes, want 1.25 and 1000", avg_packets, avg_bytes)
+	}
+	if peak_packets, peak_bytes := monitor.Peak(); peak_packets != 2 || peak_bytes != 1500 {
+		t.Errorf("peak = %d packets and %d bytes, want 2 and 1500", peak_packets, peak_bytes)
+	}
+}
+
+func TestQueueMonitorSpan(t *testing.T) {
+	monitor := NewQueueMonitor(1, 2, AbsoluteSpan(1, 3))
+	for _, trace := range parseTestTraces(t, queueTrace) {
+		monitor.Add(trace)
+	}
+	times, packets, avg_packets := monitor.Result()
+	checkSeries(t, "packets", times, packets, []float64{1, 2}, []float64{2, 1})
+
+	// 2 packets from 1 to 2 and 1 packet from 2 to 3
+	if math.Abs(avg_packets-1.5) > 1e-9 {
+		t.Errorf("average = %g packets, want 1.5", avg_packets)
+	}
+	if peak_packets, _ := monitor.Peak(); peak_packets != 2 {
+		t.Errorf("peak = %d packets, want 2", peak_packets)
+	}
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ParseMode decides what a TraceReader does with a malformed line
+type ParseMode int
+
+const (
+	Strict  ParseMode = iota // Stop at the first malformed line and report a *ParseError
+	Lenient                  // Skip malformed lines and count them
+)
+
+var (
+	errFieldCount = fmt.Errorf("expected %d fields", traceFieldCount)
+	errEmptyField = errors.New("empty field")
+)
+
+// ParseError describes a malformed line in a trace file
+type ParseError struct {
+	File  string // The trace file name, empty if the input was not a file
+	Line  int    // The 1-based line number
+	Field int    // The 0-based field index, or -1 if the line has the wrong number of fields
+	Text  string // The raw text of the line
+	Err   error  // The underlying error
+}
+
+func (e *ParseError) Error() string {
+	pos := fmt.Sprintf("line %d", e.Line)
+	if e.File != "" {
+		pos = fmt.Sprintf("%s:%d", e.File, e.Line)
+	}
+	if e.Field < 0 {
+		return fmt.Sprintf("%s: %v: %q", pos, e.Err, e.Text)
+	}
+	return fmt.Sprintf("%s: field %d: %v: %q", pos, e.Field, e.Err, e.Text)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// TraceSource is a stream of traces, such as a TraceReader or a PcapReader
+type TraceSource interface {
+	Next() bool    // Advance to the next trace. Return false at the end of input or on error
+	Trace() *Trace // Get the trace read by the last call to Next
+	Err() error    // Get the first error encountered while reading, if any
+}
+
+// Stream every trace of the source through 'fn' in a single pass
+func ScanTraces(src TraceSource, fn func(*Trace)) error {
+	for src.Next() {
+		fn(src.Trace())
+	}
+	return src.Err()
+}
+
+// TraceReader streams traces from an ns2 trace one line at a time so that
+// a trace never has to be loaded into memory in full
+type TraceReader struct {
+	scanner *bufio.Scanner
+	parse   func(line string) (*Trace, error)
+	closer  io.Closer
+	name    string
+	mode    ParseMode
+	line    int
+	skipped int
+	trace   *Trace
+	err     error
+}
+
+// Create a TraceReader that reads ns2 trace lines from r in Strict mode
+func NewTraceReader(r io.Reader) *TraceReader {
+	return &TraceReader{scanner: bufio.NewScanner(r), parse: parseTraceLine}
+}
+
+// Open a trace file for streaming. gzip and bzip2 compressed files are
+// decompressed on the fly. The caller must Close the reader when done
+func OpenTraceFile(file string) (*TraceReader, error) {
+	f, err := openDecompressed(file)
+	if err != nil {
+		return nil, err
+	}
+	reader := NewTraceReader(f)
+	reader.closer = f
+	reader.name = file
+	return reader, nil
+}
+
+// Set how malformed lines are handled. The default is Strict
+func (r *TraceReader) SetMode(mode ParseMode) {
+	r.mode = mode
+}
+
+// Advance to the next trace. Return false at the end of input or on error
+func (r *TraceReader) Next() bool {
+	for r.err == nil && r.scanner.Scan() {
+		r.line++
+		trace, err := r.parse(r.scanner.Text())
+		if err == nil {
+			if trace == nil {
+				continue // The line holds no packet event
+			}
+			r.trace = trace
+			return true
+		}
+		if r.mode == Lenient {
+			r.skipped++
+			continue
+		}
+		// A swapped-in parser may fail with any error, so give it a position too
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			perr = &ParseError{Field: -1, Text: r.scanner.Text(), Err: err}
+		}
+		perr.File = r.name
+		perr.Line = r.line
+		r.err = perr
+	}
+	return false
+}
+
+// Get the trace read by the last call to Next
+func (r *TraceReader) Trace() *Trace {
+	return r.trace
+}
+
+// Get the 1-based line number of the last line read
+func (r *TraceReader) Line() int {
+	return r.line
+}
+
+// Get the number of malformed lines skipped in Lenient mode
+func (r *TraceReader) Skipped() int {
+	return r.skipped
+}
+
+// Get the first error encountered while reading, if any
+func (r *TraceReader) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.scanner.Err()
+}
+
+// Close the underlying file if the reader was opened with OpenTraceFile
+func (r *TraceReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Stream every trace in the file through 'fn' in a single pass.
+// The first malformed line stops the scan with a *ParseError
+func ScanTraceFile(file string, fn func(*Trace)) error {
+	reader, err := OpenTraceFile(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for reader.Next() {
+		fn(reader.Trace())
+	}
+	return reader.Err()
+}
+
+// Stream every well-formed trace in the file through 'fn' in a single pass.
+// Return the number of malformed lines that were skipped
+func ScanTraceFileLenient(file string, fn func(*Trace)) (int, error) {
+	reader, err := OpenTraceFile(file)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	reader.SetMode(Lenient)
+	for reader.Next() {
+		fn(reader.Trace())
+	}
+	return reader.Skipped(), reader.Err()
+}
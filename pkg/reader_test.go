@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const goodLine = "r 1.5 1 2 tcp 1040 ------- 1 0.0 3.0 7 9"
+
+func TestParseTraceLineErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		field int
+		err   error // The wrapped error if it is a sentinel, nil to only check the field
+	}{
+		{"blank line", "", -1, errFieldCount},
+		{"short line", "r 1.5 1 2 tcp 1040 ------- 1 0.0 3.0 7", -1, errFieldCount},
+		{"long line", goodLine + " 10", -1, errFieldCount},
+		{"unknown event", "x 1.5 1 2 tcp 1040 ------- 1 0.0 3.0 7 9", 0, nil},
+		{"non-numeric time", "r 1.5s 1 2 tcp 1040 ------- 1 0.0 3.0 7 9", 1, nil},
+		{"non-numeric from", "r 1.5 one 2 tcp 1040 ------- 1 0.0 3.0 7 9", 2, nil},
+		{"empty type", "r 1.5 1 2  1040 ------- 1 0.0 3.0 7 9", 4, errEmptyField},
+		{"non-numeric size", "r 1.5 1 2 tcp big ------- 1 0.0 3.0 7 9", 5, nil},
+		{"bad flags", "r 1.5 1 2 tcp 1040 --- 1 0.0 3.0 7 9", 6, nil},
+		{"non-numeric fid", "r 1.5 1 2 tcp 1040 ------- x 0.0 3.0 7 9", 7, nil},
+		{"non-numeric seq", "r 1.5 1 2 tcp 1040 ------- 1 0.0 3.0 seven 9", 10, nil},
+		{"non-numeric pid", "r 1.5 1 2 tcp 1040 ------- 1 0.0 3.0 7 9x", 11, nil},
+	}
+	for _, test := range tests {
+		_, err := parseTraceLine(test.line)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s: err = %v, want a *ParseError", test.name, err)
+			continue
+		}
+		if perr.Field != test.field || perr.Text != test.line {
+			t.Errorf("%s: field %d and text %q, want %d and %q", test.name, perr.Field, perr.Text, test.field, test.line)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: err = %v, want it to wrap %v", test.name, err, test.err)
+		}
+	}
+}
+
+// A trace whose lines 2 and 4 are malformed
+const mixedTrace = goodLine + "\n" +
+	"r 1.5 1 2 tcp big ------- 1 0.0 3.0 7 9\n" +
+	goodLine + "\n" +
+	"\n" +
+	goodLine + "\n"
+
+func TestTraceReaderStrict(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mixed.tr")
+	if err := os.WriteFile(file, []byte(mixedTrace), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := OpenTraceFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	count := 0
+	for reader.Next() {
+		count++
+	}
+	if count != 1 {
+		t.Errorf("read %d traces before the error, want 1", count)
+	}
+
+	// The scan stops at the first malformed line and says where it is
+	var perr *ParseError
+	if !errors.As(reader.Err(), &perr) {
+		t.Fatalf("err = %v, want a *ParseError", reader.Err())
+	}
+	if perr.File != file || perr.Line != 2 || perr.Field != 5 {
+		t.Errorf("error at %s:%d field %d, want %s:2 field 5", perr.File, perr.Line, perr.Field, file)
+	}
+	want := file + ":2: field 5: "
+	if !strings.HasPrefix(perr.Error(), want) {
+		t.Errorf("error %q, want it to start with %q", perr.Error(), want)
+	}
+	if reader.Next() {
+		t.Error("Next kept reading after the error")
+	}
+}
+
+func TestTraceReaderLenient(t *testing.T) {
+	reader := NewTraceReader(strings.NewReader(mixedTrace))
+	reader.SetMode(Lenient)
+	var lines []int
+	for reader.Next() {
+		lines = append(lines, reader.Line())
+		if got := reader.Trace().String(); got != goodLine {
+			t.Errorf("line %d = %q, want %q", reader.Line(), got, goodLine)
+		}
+	}
+	if err := reader.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 3 || lines[0] != 1 || lines[1] != 3 || lines[2] != 5 {
+		t.Errorf("read lines %v, want [1 3 5]", lines)
+	}
+	if reader.Skipped() != 2 {
+		t.Errorf("skipped %d lines, want 2", reader.Skipped())
+	}
+}
+
+func TestTraceReaderWrapsOtherErrors(t *testing.T) {
+	errCustom := errors.New("custom parser failure")
+	reader := NewTraceReader(strings.NewReader(goodLine + "\nanything\n"))
+	reader.parse = func(line string) (*Trace, error) {
+		if line == goodLine {
+			return parseTraceLine(line)
+		}
+		return nil, errCustom
+	}
+	for reader.Next() {
+	}
+	var perr *ParseError
+	if !errors.As(reader.Err(), &perr) || perr.Line != 2 || perr.Field != -1 || !errors.Is(perr, errCustom) {
+		t.Errorf("err = %v, want a *ParseError on line 2 that wraps %v", reader.Err(), errCustom)
+	}
+}
@@ -0,0 +1,114 @@
+package pkg
+
+import "math"
+
+// The RFC 6298 smoothing gains of SRTT and RTTVAR, and the weight of RTTVAR in the RTO
+const (
+	rttAlpha = 1.0 / 8
+	rttBeta  = 1.0 / 4
+	rttK     = 4
+)
+
+// RTTMeter estimates the round-trip time of a TCP flow in a single pass by
+// pairing each data segment sent by the TCP source with the first ACK that
+// covers it arriving back at the source. Feed it the "tcp" and "ack" traces
+// of a single flow. ns2 numbers segments rather than bytes, and an ACK
+// carries the highest segment received in order
+type RTTMeter struct {
+	span TimeSpan
+
+	send_times    map[int]float64 // A hashmap with {key, value} of {seq, time of the first send}
+	retransmitted map[int]bool    // Segments sent more than once
+	highest_ack   int
+
+	srtt   float64
+	rttvar float64
+
+	time_ticks   []float64
+	rtt_ticks    []float64
+	srtt_ticks   []float64
+	rttvar_ticks []float64
+}
+
+// Create an RTTMeter that only records ACKs arriving inside 'span'
+func NewRTTMeter(span TimeSpan) *RTTMeter {
+	return &RTTMeter{
+		span:          span,
+		send_times:    make(map[int]float64),
+		retransmitted: make(map[int]bool),
+		highest_ack:   -1,
+	}
+}
+
+// Add the next trace. Traces must arrive in time order
+func (m *RTTMeter) Add(trace *Trace) {
+	switch {
+	case trace.Type == TCP && trace.Event == Enqueue && trace.From == trace.Src.Node:
+		// A segment sent by the source
+		if _, ok := m.send_times[trace.Seq]; ok || trace.Seq <= m.highest_ack {
+			m.retransmitted[trace.Seq] = true
+		} else {
+			m.send_times[trace.Seq] = trace.Time
+		}
+	case trace.Type == Ack && trace.Event == Receive && trace.To == trace.Dst.Node:
+		// An ACK arriving back at the source
+		if trace.Seq <= m.highest_ack {
+			return // A duplicate ACK acknowledges nothing new
+		}
+		// Karn's rule: an ACK that covers a retransmitted segment is ambiguous
+		ambiguous := false
+		for seq := range m.retransmitted {
+			if seq <= trace.Seq {
+				ambiguous = true
+				delete(m.retransmitted, seq)
+			}
+		}
+		send_time, ok := m.send_times[trace.Seq]
+		for seq := range m.send_times {
+			if seq <= trace.Seq {
+				delete(m.send_times, seq)
+			}
+		}
+		m.highest_ack = trace.Seq
+		if ok && !ambiguous && m.span.Contains(trace.Time) {
+			m.sample(trace.Time, trace.Time-send_time)
+		}
+	}
+}
+
+// Record an RTT sample and update SRTT and RTTVAR as in RFC 6298
+func (m *RTTMeter) sample(time float64, rtt float64) {
+	if len(m.rtt_ticks) == 0 {
+		m.srtt = rtt
+		m.rttvar = rtt / 2
+	} else {
+		m.rttvar = (1-rttBeta)*m.rttvar + rttBeta*math.Abs(m.srtt-rtt)
+		m.srtt = (1-rttAlpha)*m.srtt + rttAlpha*rtt
+	}
+	m.time_ticks = append(m.time_ticks, time)
+	m.rtt_ticks = append(m.rtt_ticks, rtt)
+	m.srtt_ticks = append(m.srtt_ticks, m.srtt)
+	m.rttvar_ticks = append(m.rttvar_ticks, m.rttvar)
+}
+
+// Return slice times, slice RTT samples, and average RTT
+func (m *RTTMeter) Result() ([]float64, []float64, float64) {
+	return m.time_ticks, m.rtt_ticks, Mean(m.rtt_ticks)
+}
+
+// Return slice SRTT and slice RTTVAR after each RTT sample
+func (m *RTTMeter) Smoothed() ([]float64, []float64) {
+	return m.srtt_ticks, m.rttvar_ticks
+}
+
+// Get the current SRTT and RTTVAR
+func (m *RTTMeter) Current() (float64, float64) {
+	return m.srtt, m.rttvar
+}
+
+// Get the retransmission timeout of RFC 6298, SRTT + 4 * RTTVAR. The clock
+// granularity and the 1 second minimum are left out, since ns2 agents set
+// their own. It is zero before the first sample
+func (m *RTTMeter) RTO() float64 {
+	return m.srtt + rttK*m.rttvar
+}
@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"math"
+	"testing"
+)
+
+// Segments 0 to 2 of a flow from node 0 to node 3 with RTTs of 100ms, 200ms
+// and 100ms. Segment 3 is retransmitted before its ACK arrives, and segment 4
+// gives one more sample of 100ms
+const rttTrace = `+ 1.0 0 1 tcp 1040 ------- 1 0.0 3.0 0 0
+r 1.1 1 0 ack 40 ------- 1 3.0 0.0 0 1
++ 1.2 0 1 tcp 1040 ------- 1 0.0 3.0 1 2
+r 1.4 1 0 ack 40 ------- 1 3.0 0.0 1 3
++ 1.5 0 1 tcp 1040 ------- 1 0.0 3.0 2 4
+r 1.6 1 0 ack 40 ------- 1 3.0 0.0 2 5
++ 2.0 0 1 tcp 1040 ------- 1 0.0 3.0 3 6
++ 2.5 0 1 tcp 1040 ------- 1 0.0 3.0 3 7
+r 2.6 1 0 ack 40 ------- 1 3.0 0.0 3 8
+r 2.65 1 0 ack 40 ------- 1 3.0 0.0 3 9
++ 2.7 0 1 tcp 1040 ------- 1 0.0 3.0 4 10
+r 2.8 1 0 ack 40 ------- 1 3.0 0.0 4 11
+`
+
+func TestRTTMeterSmoothing(t *testing.T) {
+	meter := NewRTTMeter(FullSpan())
+	for _, trace := range parseTestTraces(t, rttTrace)[:6] {
+		meter.Add(trace)
+	}
+	times, rtts, _ := meter.Result()
+	checkSeries(t, "rtt", times, rtts, []float64{1.1, 1.4, 1.6}, []float64{0.1, 0.2, 0.1})
+
+	// By hand: SRTT 0.1, 0.1125, 0.1109375 and RTTVAR 0.05, 0.0625, 0.05
+	srtt, rttvar := meter.Smoothed()
+	checkSeries(t, "srtt and rttvar", srtt, rttvar, []float64{0.1, 0.1125, 0.1109375}, []float64{0.05, 0.0625, 0.05})
+	if rto := meter.RTO(); math.Abs(rto-0.3109375) > 1e-9 {
+		t.Errorf("RTO = %g, want 0.1109375 + 4 * 0.05 = 0.3109375", rto)
+	}
+}
+
+func TestRTTMeterKarn(t *testing.T) {
+	meter := NewRTTMeter(FullSpan())
+	for _, trace := range parseTestTraces(t, rttTrace) {
+		meter.Add(trace)
+	}
+
+	// The ACK of the retransmitted segment 3 and its duplicate give no sample
+	times, rtts, _ := meter.Result()
+	checkSeries(t, "rtt", times, rtts, []float64{1.1, 1.4, 1.6, 2.8}, []float64{0.1, 0.2, 0.1, 0.1})
+	if len(meter.send_times) != 0 || len(meter.retransmitted) != 0 {
+		t.Errorf("%d send times and %d retransmissions remembered after every segment was acked", len(meter.send_times), len(meter.retransmitted))
+	}
+}
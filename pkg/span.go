@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+)
+
+// TimeSpan is the half-open time window [Start, End) that a metric is
+// calculated over. Traces outside the span are ignored
+type TimeSpan struct {
+	Start float64
+	End   float64
+}
+
+// Get the span that covers the whole trace
+func FullSpan() TimeSpan {
+	return TimeSpan{Start: math.Inf(-1), End: math.Inf(1)}
+}
+
+// Get the span from 'start' to 'end' in simulation time
+func AbsoluteSpan(start float64, end float64) TimeSpan {
+	return TimeSpan{Start: start, End: end}
+}
+
+// Get the span of 'length' seconds that begins 'offset' seconds after the flow starts
+func RelativeSpan(flow_start float64, offset float64, length float64) TimeSpan {
+	return TimeSpan{Start: flow_start + offset, End: flow_start + offset + length}
+}
+
+// Get the span that drops the first 'warmup' seconds of a flow and keeps the rest
+func WarmupSpan(flow_start float64, warmup float64) TimeSpan {
+	return TimeSpan{Start: flow_start + warmup, End: math.Inf(1)}
+}
+
+// Check if 'time' is inside the span
+func (s TimeSpan) Contains(time float64) bool {
+	return time >= s.Start && time < s.End
+}
+
+// Get the length of the span in seconds
+func (s TimeSpan) Length() float64 {
+	return s.End - s.Start
+}
+
+// Get a filter that keeps only the traces inside the span
+func (s TimeSpan) Filter() TraceFilter {
+	return TimeFilter(s.Start, s.End)
+}
+
+func (s TimeSpan) String() string {
+	return fmt.Sprintf("[%g, %g)", s.Start, s.End)
+}
@@ -1,6 +1,9 @@
 package pkg
 
-import "math"
+import (
+	"math"
+	"sort"
+)
 
 // Get the sum from a slice of float64
 func Sum(arr []float64) float64 {
@@ -51,3 +54,47 @@ func StdDev(arr []float64) float64 {
 	}
 	return math.Sqrt(sum / float64(len(arr)))
 }
+
+// Get the p-th percentile (0 to 100) from a slice of float64, interpolating
+// linearly between the closest ranks
+func Percentile(arr []float64, p float64) float64 {
+	if len(arr) == 0 {
+		return math.NaN()
+	}
+	sorted := append([]float64(nil), arr...)
+	sort.Float64s(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// Summary holds the descriptive statistics of a sample
+type Summary struct {
+	Count  int
+	Mean   float64
+	StdDev float64
+	Min    float64
+	Max    float64
+	Median float64
+	P95    float64
+	P99    float64
+}
+
+// Get the descriptive statistics from a slice of float64
+func Summarize(arr []float64) Summary {
+	if len(arr) == 0 {
+		nan := math.NaN()
+		return Summary{Mean: nan, StdDev: nan, Min: nan, Max: nan, Median: nan, P95: nan, P99: nan}
+	}
+	return Summary{
+		Count:  len(arr),
+		Mean:   Mean(arr),
+		StdDev: StdDev(arr),
+		Min:    Min(arr),
+		Max:    Max(arr),
+		Median: Percentile(arr, 50),
+		P95:    Percentile(arr, 95),
+		P99:    Percentile(arr, 99),
+	}
+}
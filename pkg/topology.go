@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ns2Topology is the static part of an ns2 simulation script: its nodes,
+// the bandwidth of every link and the links with a queue limit. ns2 numbers
+// the nodes in the order they are created, so the node named "n1" in the
+// script is node 0 in the trace
+type Ns2Topology struct {
+	Nodes       []string         // The variable name of each node, indexed by node id
+	Bandwidths  map[Link]float64 // The bandwidth of each link in bits per second, both ways for a duplex link
+	QueueLimits map[Link]int     // The queue limit in packets of each link the script sets one on
+}
+
+// Read the topology of the ns2 simulation script 'file'
+func ReadNs2Topology(file string) (*Ns2Topology, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	topology, err := ParseNs2Topology(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return topology, nil
+}
+
+// Parse the topology of an ns2 simulation script. Only the 'set x [$ns node]',
+// 'duplex-link', 'simplex-link' and 'queue-limit' statements are read
+func ParseNs2Topology(r io.Reader) (*Ns2Topology, error) {
+	topology := &Ns2Topology{Bandwidths: make(map[Link]float64), QueueLimits: make(map[Link]int)}
+	ids := make(map[string]int) // A hashmap with {key, value} of {node variable, node id}
+	node := func(s string) (int, error) {
+		id, ok := ids[strings.TrimPrefix(s, "$")]
+		if !ok {
+			return 0, fmt.Errorf("unknown node %q", s)
+		}
+		return id, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) == 4 && fields[0] == "set" && fields[2] == "[$ns" && fields[3] == "node]" {
+			ids[fields[1]] = len(topology.Nodes)
+			topology.Nodes = append(topology.Nodes, fields[1])
+			continue
+		}
+		if len(fields) < 2 || fields[0] != "$ns" {
+			continue
+		}
+		switch fields[1] {
+		case "duplex-link", "simplex-link", "queue-limit":
+		default:
+			continue
+		}
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("line %d: %s needs two nodes and a value", line, fields[1])
+		}
+		from, err := node(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		to, err := node(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		link := Link{From: from, To: to}
+		switch fields[1] {
+		case "queue-limit":
+			limit, err := strconv.Atoi(fields[4])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: queue limit: %w", line, err)
+			}
+			topology.QueueLimits[link] = limit
+		default:
+			bandwidth, err := ParseBandwidth(fields[4])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			topology.Bandwidths[link] = bandwidth
+			if fields[1] == "duplex-link" {
+				topology.Bandwidths[Link{From: to, To: from}] = bandwidth
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return topology, nil
+}
+
+// Parse an ns2 bandwidth such as "10Mb", "1.5Mb" or "64kb" into bits per
+// second. A trailing 'B' counts bytes instead of bits
+func ParseBandwidth(s string) (float64, error) {
+	text := s
+	scale := 1.0
+	if strings.HasSuffix(text, "B") {
+		scale = 8
+	}
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "b"), "B")
+	if text != "" {
+		prefixes := map[byte]float64{'k': 1e3, 'K': 1e3, 'm': 1e6, 'M': 1e6, 'g': 1e9, 'G': 1e9}
+		if prefix, ok := prefixes[text[len(text)-1]]; ok {
+			scale *= prefix
+			text = text[:len(text)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bandwidth %q: %w", s, err)
+	}
+	return value * scale, nil
+}
+
+// Get the bottleneck of the script, the link with the smallest queue limit.
+// Return false if the script sets no queue limit
+func (t *Ns2Topology) Bottleneck() (Link, bool) {
+	links := make([]Link, 0, len(t.QueueLimits))
+	for link := range t.QueueLimits {
+		links = append(links, link)
+	}
+	if len(links) == 0 {
+		return Link{}, false
+	}
+	// Break ties by node ids so the choice is the same every run
+	sort.Slice(links, func(i, j int) bool {
+		a, b := links[i], links[j]
+		if t.QueueLimits[a] != t.QueueLimits[b] {
+			return t.QueueLimits[a] < t.QueueLimits[b]
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return links[0], true
+}
+
+// Get the name of the link as the script's node variables, such as "n2->n3"
+func (t *Ns2Topology) LinkName(link Link) string {
+	name := func(id int) string {
+		if id >= 0 && id < len(t.Nodes) {
+			return t.Nodes[id]
+		}
+		return strconv.Itoa(id)
+	}
+	return name(link.From) + "->" + name(link.To)
+}
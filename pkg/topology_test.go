@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSimulationTopologies(t *testing.T) {
+	for _, script := range []string{"simulation01.tcl", "simulation02.tcl", "simulation03.tcl"} {
+		topology, err := ReadNs2Topology("../ns2/" + script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The experiments label the bottleneck N2-N3, which is node 1 -> node 2 in the trace
+		bottleneck, ok := topology.Bottleneck()
+		if !ok || bottleneck != (Link{1, 2}) || topology.LinkName(bottleneck) != "n2->n3" {
+			t.Errorf("%s: bottleneck = %v (%s), want {1 2} (n2->n3)", script, bottleneck, topology.LinkName(bottleneck))
+		}
+		if len(topology.Nodes) != 6 || len(topology.Bandwidths) != 10 {
+			t.Errorf("%s: %d nodes and %d links, want 6 and 10", script, len(topology.Nodes), len(topology.Bandwidths))
+		}
+		if topology.Bandwidths[Link{2, 1}] != 10e6 {
+			t.Errorf("%s: n3->n2 bandwidth = %g, want 10e6", script, topology.Bandwidths[Link{2, 1}])
+		}
+	}
+}
+
+func TestParseNs2Topology(t *testing.T) {
+	script := `set ns [new Simulator]
+set a [$ns node]
+set b [$ns node]
+set c [$ns node]
+# $ns duplex-link $a $c 1Mb 10ms DropTail
+$ns duplex-link $a $b 1.5Mb 10ms DropTail
+$ns simplex-link $b $c 64kb 5ms DropTail
+$ns queue-limit $b $c 10
+$ns queue-limit $a $b 20
+`
+	topology, err := ParseNs2Topology(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[Link]float64{{0, 1}: 1.5e6, {1, 0}: 1.5e6, {1, 2}: 64e3}
+	if len(topology.Bandwidths) != len(want) {
+		t.Errorf("bandwidths = %v, want %v", topology.Bandwidths, want)
+	}
+	for link, bandwidth := range want {
+		if topology.Bandwidths[link] != bandwidth {
+			t.Errorf("bandwidths = %v, want %v", topology.Bandwidths, want)
+		}
+	}
+	if bottleneck, _ := topology.Bottleneck(); bottleneck != (Link{1, 2}) {
+		t.Errorf("bottleneck = %v, want the smallest queue limit {1 2}", bottleneck)
+	}
+
+	_, err = ParseNs2Topology(strings.NewReader("set a [$ns node]\n$ns duplex-link $a $z 1Mb 10ms DropTail\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("unknown node: err = %v, want an error on line 2", err)
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		text string
+		want float64
+	}{
+		{"10Mb", 10e6},
+		{"1.5Mb", 1.5e6},
+		{"64kb", 64e3},
+		{"1Gb", 1e9},
+		{"1MB", 8e6},
+		{"500", 500},
+	}
+	for _, test := range tests {
+		got, err := ParseBandwidth(test.text)
+		if err != nil || got != test.want {
+			t.Errorf("ParseBandwidth(%q) = %g, %v, want %g", test.text, got, err, test.want)
+		}
+	}
+	if _, err := ParseBandwidth("fast"); err == nil {
+		t.Error("ParseBandwidth(\"fast\") should fail")
+	}
+}
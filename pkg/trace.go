@@ -1,81 +1,305 @@
 package pkg
 
 import (
-	"bufio"
-	"os"
+	"fmt"
 	"sort"
 	"strconv"
 	"strings"
 )
 
+// Event is the kind of event an ns2 trace line records
+type Event byte
+
+const (
+	Enqueue Event = '+' // The packet entered the link queue
+	Dequeue Event = '-' // The packet left the link queue
+	Receive Event = 'r' // The packet was received at the end of the link
+	Drop    Event = 'd' // The packet was dropped by the link queue
+)
+
+// Parse the event field of an ns2 trace line
+func ParseEvent(s string) (Event, error) {
+	if len(s) == 1 {
+		switch e := Event(s[0]); e {
+		case Enqueue, Dequeue, Receive, Drop:
+			return e, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown event %q", s)
+}
+
+// String function for Event
+func (e Event) String() string {
+	return string(rune(e))
+}
+
+// PacketType is the packet type name of an ns2 trace line
+type PacketType string
+
+const (
+	TCP PacketType = "tcp"
+	Ack PacketType = "ack"
+	CBR PacketType = "cbr"
+	UDP PacketType = "udp"
+)
+
+// Flags are the packet flags of an ns2 trace line. ns2 prints them as a
+// 7 character field with one letter per position, or '-' when unset
+type Flags uint8
+
+const (
+	FlagECNEcho   Flags = 1 << 0 // 'C' ECN echo in the TCP header
+	FlagPriority  Flags = 1 << 1 // 'P' priority
+	FlagCWR       Flags = 1 << 3 // 'A' congestion window reduced (congestion action)
+	FlagCE        Flags = 1 << 4 // 'E' congestion experienced in the IP header
+	FlagFastStart Flags = 1 << 5 // 'F' TCP fast start
+	FlagECT       Flags = 1 << 6 // 'N' ECN capable transport in the IP header
+)
+
+// The letter ns2 prints for each flag position. Position 2 is never set
+const flagLetters = "CP-AEFN"
+
+// Parse the flags field of an ns2 trace line
+func ParseFlags(s string) (Flags, error) {
+	if len(s) != len(flagLetters) {
+		return 0, fmt.Errorf("flags %q must be %d characters", s, len(flagLetters))
+	}
+	var flags Flags
+	for i := 0; i < len(s); i++ {
+		if s[i] == '-' {
+			continue
+		}
+		if s[i] != flagLetters[i] {
+			return 0, fmt.Errorf("unknown flag %q at position %d", s[i], i)
+		}
+		flags |= 1 << i
+	}
+	return flags, nil
+}
+
+// Report whether every flag in 'f' is set
+func (f Flags) Has(flag Flags) bool {
+	return f&flag == flag
+}
+
+// String function for Flags
+func (f Flags) String() string {
+	buf := []byte("-------")
+	for i := 0; i < len(buf); i++ {
+		if f&(1<<i) != 0 {
+			buf[i] = flagLetters[i]
+		}
+	}
+	return string(buf)
+}
+
+// Link is a directed link between two nodes
+type Link struct {
+	From int
+	To   int
+}
+
+// Addr is an ns2 agent address printed as node.port
+type Addr struct {
+	Node int
+	Port int
+}
+
+// Parse a node.port address field of an ns2 trace line
+func ParseAddr(s string) (Addr, error) {
+	dot := strings.IndexByte(s, '.')
+	if dot < 0 {
+		return Addr{}, fmt.Errorf("address %q is not node.port", s)
+	}
+	node, err := strconv.Atoi(s[:dot])
+	if err != nil {
+		return Addr{}, err
+	}
+	port, err := strconv.Atoi(s[dot+1:])
+	if err != nil {
+		return Addr{}, err
+	}
+	return Addr{Node: node, Port: port}, nil
+}
+
+// String function for Addr
+func (a Addr) String() string {
+	return strconv.Itoa(a.Node) + "." + strconv.Itoa(a.Port)
+}
+
+// Trace is a single line of an ns2 trace file
 type Trace struct {
-	event       string
-	time        float64
-	from        int
-	to          int
-	packet_type string
-	packet_size int
-	fid         int
-	seq         int
-	pid         int
+	Event Event      // The event kind (+, -, r, d)
+	Time  float64    // The event time in seconds
+	From  int        // The node at the start of the link
+	To    int        // The node at the end of the link
+	Type  PacketType // The packet type (tcp, ack, cbr, udp)
+	Size  int        // The packet size in bytes
+	Flags Flags      // The ECN, priority and fast start flags
+	Fid   int        // The flow id
+	Src   Addr       // The source agent address
+	Dst   Addr       // The destination agent address
+	Seq   int        // The sequence number
+	Pid   int        // The unique packet id
+
+	time_text string // The original time text, kept only when FormatFloat would not reproduce it
+}
+
+// Create a Trace from its fields. Flags and addresses can be set on the result
+func NewTrace(event Event, time float64, from int, to int, packet_type PacketType, packet_size int, fid int, seq int, pid int) *Trace {
+	return &Trace{
+		Event: event,
+		Time:  time,
+		From:  from,
+		To:    to,
+		Type:  packet_type,
+		Size:  packet_size,
+		Fid:   fid,
+		Seq:   seq,
+		Pid:   pid,
+	}
 }
 
 // String function for Trace struct
 func (t *Trace) String() string {
-	str := t.event + " " +
-		strconv.FormatFloat(t.time, 'f', -1, 64) + " " +
-		strconv.Itoa(t.from) + " " +
-		strconv.Itoa(t.to) + " " +
-		t.packet_type + " " +
-		strconv.Itoa(t.packet_size) + " " +
-		"-------" + " " +
-		strconv.Itoa(t.fid) + " " +
-		strconv.Itoa(t.seq) + " " +
-		strconv.Itoa(t.pid)
-	return str
-}
-
-// Parse the trace file and return a slice of Trace structs
-func ParseTraceFile(file string) ([]*Trace, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
+	return string(t.appendText(nil))
+}
+
+// Append the ns2 trace line of the trace to 'buf', without a newline
+func (t *Trace) appendText(buf []byte) []byte {
+	buf = append(buf, byte(t.Event), ' ')
+	buf = t.appendTime(buf)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(t.From), 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(t.To), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, t.Type...)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(t.Size), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, t.Flags.String()...)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(t.Fid), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, t.Src.String()...)
+	buf = append(buf, ' ')
+	buf = append(buf, t.Dst.String()...)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(t.Seq), 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(t.Pid), 10)
+	return buf
+}
+
+// Append the time the way it appeared in the trace file, as long as the
+// time has not been changed since it was parsed
+func (t *Trace) appendTime(buf []byte) []byte {
+	if t.time_text != "" {
+		if time, err := strconv.ParseFloat(t.time_text, 64); err == nil && time == t.Time {
+			return append(buf, t.time_text...)
+		}
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
+	return strconv.AppendFloat(buf, t.Time, 'f', -1, 64)
+}
+
+// Parse the trace file and return a slice of Trace structs.
+// The first malformed line stops parsing with a *ParseError
+func ParseTraceFile(file string) ([]*Trace, error) {
 	var traces []*Trace
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Split(line, " ")
-		time, _ := strconv.ParseFloat(fields[1], 64)
-		from, _ := strconv.Atoi(fields[2])
-		to, _ := strconv.Atoi(fields[3])
-		packet_size, _ := strconv.Atoi(fields[5])
-		fid, _ := strconv.Atoi(fields[7])
-		seq, _ := strconv.Atoi(fields[10])
-		pid, _ := strconv.Atoi(fields[11])
-
-		trace := &Trace{
-			event:       fields[0],
-			time:        time,
-			from:        from,
-			to:          to,
-			packet_type: fields[4],
-			packet_size: packet_size,
-			fid:         fid,
-			seq:         seq,
-			pid:         pid,
-		}
+	err := ScanTraceFile(file, func(trace *Trace) {
 		traces = append(traces, trace)
-	}
+	})
 	return traces, err
 }
 
+// Parse the trace file and return a slice of Trace structs.
+// Malformed lines are skipped and the number of skipped lines is returned
+func ParseTraceFileLenient(file string) ([]*Trace, int, error) {
+	var traces []*Trace
+	skipped, err := ScanTraceFileLenient(file, func(trace *Trace) {
+		traces = append(traces, trace)
+	})
+	return traces, skipped, err
+}
+
+// The number of space separated fields in an ns2 trace line
+const traceFieldCount = 12
+
+// Parse a single line of an ns2 trace file. A malformed line returns a
+// *ParseError with the offending field index set
+func parseTraceLine(line string) (*Trace, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) != traceFieldCount {
+		return nil, &ParseError{Field: -1, Text: line, Err: errFieldCount}
+	}
+
+	var err error
+	trace := &Trace{Type: PacketType(fields[4])}
+	if trace.Event, err = ParseEvent(fields[0]); err != nil {
+		return nil, &ParseError{Field: 0, Text: line, Err: err}
+	}
+	if trace.Time, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return nil, &ParseError{Field: 1, Text: line, Err: err}
+	}
+	if strconv.FormatFloat(trace.Time, 'f', -1, 64) != fields[1] {
+		trace.time_text = fields[1]
+	}
+	if trace.From, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, &ParseError{Field: 2, Text: line, Err: err}
+	}
+	if trace.To, err = strconv.Atoi(fields[3]); err != nil {
+		return nil, &ParseError{Field: 3, Text: line, Err: err}
+	}
+	if trace.Type == "" {
+		return nil, &ParseError{Field: 4, Text: line, Err: errEmptyField}
+	}
+	if trace.Size, err = strconv.Atoi(fields[5]); err != nil {
+		return nil, &ParseError{Field: 5, Text: line, Err: err}
+	}
+	if trace.Flags, err = ParseFlags(fields[6]); err != nil {
+		return nil, &ParseError{Field: 6, Text: line, Err: err}
+	}
+	if trace.Fid, err = strconv.Atoi(fields[7]); err != nil {
+		return nil, &ParseError{Field: 7, Text: line, Err: err}
+	}
+	if trace.Src, err = ParseAddr(fields[8]); err != nil {
+		return nil, &ParseError{Field: 8, Text: line, Err: err}
+	}
+	if trace.Dst, err = ParseAddr(fields[9]); err != nil {
+		return nil, &ParseError{Field: 9, Text: line, Err: err}
+	}
+	if trace.Seq, err = strconv.Atoi(fields[10]); err != nil {
+		return nil, &ParseError{Field: 10, Text: line, Err: err}
+	}
+	if trace.Pid, err = strconv.Atoi(fields[11]); err != nil {
+		return nil, &ParseError{Field: 11, Text: line, Err: err}
+	}
+	return trace, nil
+}
+
+// A TraceFilter reports whether a trace should be kept
+type TraceFilter func(*Trace) bool
+
+// Keep only traces of flow id 'fid'
+func FidFilter(fid int) TraceFilter {
+	return func(trace *Trace) bool {
+		return trace.Fid == fid
+	}
+}
+
+// Keep only traces of type 'packet_type' (tcp, cbr, ack)
+func TypeFilter(packet_type PacketType) TraceFilter {
+	return func(trace *Trace) bool {
+		return trace.Type == packet_type
+	}
+}
+
 // Get a slice of traces of flow id 'fid'
 func FilterByFid(traces []*Trace, fid int) []*Trace {
 	var filtered []*Trace
 	for _, trace := range traces {
-		if trace.fid == fid {
+		if trace.Fid == fid {
 			filtered = append(filtered, trace)
 		}
 	}
@@ -83,107 +307,113 @@ func FilterByFid(traces []*Trace, fid int) []*Trace {
 }
 
 // Get a slice of traces of type 'packet_type' (tcp, cbr, ack)
-func FilterByType(traces []*Trace, packet_type string) []*Trace {
+func FilterByType(traces []*Trace, packet_type PacketType) []*Trace {
 	var filtered []*Trace
 	for _, trace := range traces {
-		if trace.packet_type == packet_type {
+		if trace.Type == packet_type {
 			filtered = append(filtered, trace)
 		}
 	}
 	return filtered
 }
 
-// Calculate throughput vs time given a TCP flow start time
+// Calculate throughput vs time over the time span 'span' with a sliding window of 'window_size' seconds
 // Return slice times, slice throughputs, and average throughput
-func CalculateThroughput(traces []*Trace, from_node int, to_node int, flow_start float64, window_size float64) ([]float64, []float64, float64) {
-	var time_ticks []float64
-	var throughput_ticks []float64
+func CalculateThroughput(traces []*Trace, from_node int, to_node int, span TimeSpan, window_size float64) ([]float64, []float64, float64) {
+	return CalculateWindowedThroughput(traces, from_node, to_node, span, NewSlidingWindow(window_size))
+}
 
-	var recv_times []float64
+// Calculate throughput vs time over the time span 'span' with any Window
+// Return slice times, slice throughputs, and average throughput
+func CalculateWindowedThroughput(traces []*Trace, from_node int, to_node int, span TimeSpan, window Window) ([]float64, []float64, float64) {
+	var recv_traces []*Trace
 	for _, trace := range traces {
-		if trace.event == "r" && trace.from == from_node && trace.to == to_node {
-			recv_times = append(recv_times, trace.time)
+		if trace.Event == Receive && trace.From == from_node && trace.To == to_node && span.Contains(trace.Time) {
+			recv_traces = append(recv_traces, trace)
 		}
 	}
+	sort.SliceStable(recv_traces, func(i, j int) bool {
+		return recv_traces[i].Time < recv_traces[j].Time
+	})
 
-	sort.Float64s(recv_times)
-
-	var head int           // The index of the window head
-	var tail int           // The index of the window tail
-	var win_throughput int // The number of bytes in the current window
-	var tot_throughput int // The total running throughput
-
-	for head < len(recv_times) {
-		// If a new trace enters the window
-		if recv_times[head] < recv_times[tail]+window_size {
-			win_throughput += traces[head].packet_size
-			tot_throughput += traces[head].packet_size
-			time_ticks = append(time_ticks, recv_times[head])
-			head++
-			// If a trace leaves the window
-		} else if recv_times[head] > recv_times[tail]+window_size {
-			win_throughput -= traces[tail].packet_size
-			time_ticks = append(time_ticks, recv_times[tail]+window_size)
-			tail++
-			// If a trace enters the window and leaves the window at the same time
-		} else {
-			win_throughput += traces[head].packet_size - traces[tail].packet_size
-			tot_throughput += traces[head].packet_size
-			time_ticks = append(time_ticks, recv_times[head])
-			head++
-			tail++
-		}
-		throughput_ticks = append(throughput_ticks, (float64(win_throughput))/window_size/125000) // In Mbps
+	meter := NewWindowedThroughputMeter(from_node, to_node, span, window)
+	for _, trace := range recv_traces {
+		meter.Add(trace)
 	}
-	avg_throughput := (float64(tot_throughput) / (Max(time_ticks) - Min(time_ticks) - window_size)) / 125000 // In Mbps
-	return time_ticks, throughput_ticks, avg_throughput
+	return meter.Result()
 }
 
-// Calculate latency vs time given a TCP flow start time
-// Return slice times, slice throughputs, and average latency
-func CalculateLatency(traces []*Trace, from_node int, to_node int, flow_start float64) ([]float64, []float64, float64) {
-	var time_ticks []float64
-	var latency_ticks []float64
+// Calculate latency vs time over the time span 'span'
+// Return slice times, slice latencies, and average latency
+func CalculateLatency(traces []*Trace, from_node int, to_node int, span TimeSpan) ([]float64, []float64, float64) {
+	meter := NewLatencyMeter(from_node, to_node, span)
+	for _, trace := range traces {
+		meter.Add(trace)
+	}
+	return meter.Result()
+}
 
-	// A hashmap with {key, value} of {pid, Trace}
-	start_traces := make(map[int]*Trace) // Traces of event '+'
-	end_traces := make(map[int]*Trace)   // Traces of event 'r'
+// Calculate the end-to-end delay vs time over the time span 'span'. The trace
+// should already be filtered by fid
+// Return slice times, slice delays, and average delay
+func CalculateDelay(traces []*Trace, span TimeSpan) ([]float64, []float64, float64) {
+	meter := NewDelayMeter(span)
+	for _, trace := range traces {
+		meter.Add(trace)
+	}
+	return meter.Result()
+}
 
-	// Iterate backwards through the trace list
-	// If you encounter a 'd', skip the next 2 traces
-	// The next 2 traces will always be '+' and 'r' at the exact same time
-	for i := len(traces) - 1; i >= 0; i-- {
-		trace := traces[i]
-		if trace.event == "d" {
-			i -= 3
-			continue
-		} else if trace.event == "+" && trace.from == from_node && trace.to == to_node {
-			start_traces[trace.pid] = trace
-		} else if trace.event == "r" && trace.from == from_node && trace.to == to_node {
-			end_traces[trace.pid] = trace
-		}
+// Calculate the round-trip time vs time over the time span 'span'. The trace
+// should already be filtered by fid
+// Return slice times, slice RTT samples, and average RTT
+func CalculateRTT(traces []*Trace, span TimeSpan) ([]float64, []float64, float64) {
+	meter := NewRTTMeter(span)
+	for _, trace := range traces {
+		meter.Add(trace)
 	}
+	return meter.Result()
+}
 
-	// In some cases, the number of start_traces > end_traces. That's ok.
-	// A failed hashmap lookup is just a no-op
-	for _, end_trace := range end_traces {
-		start_trace, ok := start_traces[end_trace.pid] // Find the matches
-		if ok {
-			latency := end_trace.time - start_trace.time
-			time_ticks = append(time_ticks, end_trace.time)
-			latency_ticks = append(latency_ticks, latency)
-		}
+// Calculate goodput and retransmissions over the time span 'span'. The trace
+// should already be filtered by fid
+// Return goodput, retransmissions, and retransmission ratio
+func CalculateGoodput(traces []*Trace, span TimeSpan) (float64, int, float64) {
+	meter := NewGoodputMeter(span)
+	for _, trace := range traces {
+		meter.Add(trace)
 	}
-	return time_ticks, latency_ticks, Mean(latency_ticks)
+	return meter.Result()
 }
 
-// Count the number of dropped packets. The trace should already be filtered by fid
-func CountDrops(traces []*Trace) int {
-	var drops int
+// Calculate Jain's fairness index between the flows 'fids' vs time over a
+// sliding window of 'window_size' seconds inside the time span 'span'
+// Return slice times, slice fairness indexes, and average fairness index
+func CalculateFairness(traces []*Trace, from_node int, to_node int, span TimeSpan, window_size float64, fids ...int) ([]float64, []float64, float64) {
+	meter := NewFairnessMeter(from_node, to_node, span, window_size, fids...)
 	for _, trace := range traces {
-		if trace.event == "d" {
-			drops++
-		}
+		meter.Add(trace)
+	}
+	return meter.Result()
+}
+
+// Analyze which packets were lost, in what bursts, among the packets sent
+// inside the time span 'span'. The trace should already be filtered by fid
+// and packet type
+func AnalyzeLoss(traces []*Trace, span TimeSpan) *LossReport {
+	meter := NewLossMeter(span)
+	for _, trace := range traces {
+		meter.Add(trace)
+	}
+	return meter.Result()
+}
+
+// Count the number of dropped packets inside the time span 'span'. The trace
+// should already be filtered by fid
+func CountDrops(traces []*Trace, span TimeSpan) int {
+	counter := NewDropCounter(span)
+	for _, trace := range traces {
+		counter.Add(trace)
 	}
-	return drops
+	return counter.Result()
 }
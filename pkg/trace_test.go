@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		text string
+		want Flags
+	}{
+		{"-------", 0},
+		{"---A---", FlagCWR},
+		{"C-----N", FlagECNEcho | FlagECT},
+		{"CP-AEFN", FlagECNEcho | FlagPriority | FlagCWR | FlagCE | FlagFastStart | FlagECT},
+	}
+	for _, test := range tests {
+		got, err := ParseFlags(test.text)
+		if err != nil || got != test.want {
+			t.Errorf("ParseFlags(%q) = %v, %v, want %v", test.text, got, err, test.want)
+		}
+		if got.String() != test.text {
+			t.Errorf("Flags(%q).String() = %q", test.text, got.String())
+		}
+	}
+	for _, text := range []string{"", "------", "--------", "A------", "--X----", "--A----"} {
+		if _, err := ParseFlags(text); err == nil {
+			t.Errorf("ParseFlags(%q) should fail", text)
+		}
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		text string
+		want Addr
+	}{
+		{"0.0", Addr{0, 0}},
+		{"3.1", Addr{3, 1}},
+		{"10.255", Addr{10, 255}},
+		{"-1.0", Addr{-1, 0}},
+	}
+	for _, test := range tests {
+		got, err := ParseAddr(test.text)
+		if err != nil || got != test.want {
+			t.Errorf("ParseAddr(%q) = %v, %v, want %v", test.text, got, err, test.want)
+		}
+		if got.String() != test.text {
+			t.Errorf("Addr(%q).String() = %q", test.text, got.String())
+		}
+	}
+	for _, text := range []string{"", "1", "x.y", "1.y", "x.1", "1.", ".1", "1.2.3"} {
+		if _, err := ParseAddr(text); err == nil {
+			t.Errorf("ParseAddr(%q) should fail", text)
+		}
+	}
+}
+
+func TestParseTraceLineFields(t *testing.T) {
+	// Lines as ns2 writes them for simulation01.tcl, one with the congestion action flag set
+	tests := []struct {
+		line string
+		want Trace
+	}{
+		{"+ 0 1 2 cbr 1000 ------- 2 1.0 2.0 0 600",
+			Trace{Event: Enqueue, Time: 0, From: 1, To: 2, Type: CBR, Size: 1000, Fid: 2,
+				Src: Addr{1, 0}, Dst: Addr{2, 0}, Seq: 0, Pid: 600}},
+		{"r 1.234567 2 3 tcp 1040 ---A--- 1 0.0 3.0 148 1017",
+			Trace{Event: Receive, Time: 1.234567, From: 2, To: 3, Type: TCP, Size: 1040, Flags: FlagCWR, Fid: 1,
+				Src: Addr{0, 0}, Dst: Addr{3, 0}, Seq: 148, Pid: 1017}},
+		{"d 4.5 1 2 ack 40 ------- 1 3.0 0.0 23 511",
+			Trace{Event: Drop, Time: 4.5, From: 1, To: 2, Type: Ack, Size: 40, Fid: 1,
+				Src: Addr{3, 0}, Dst: Addr{0, 0}, Seq: 23, Pid: 511}},
+	}
+	for _, test := range tests {
+		got, err := parseTraceLine(test.line)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+		if *got != test.want {
+			t.Errorf("%q parsed as %+v, want %+v", test.line, *got, test.want)
+		}
+	}
+
+	// A malformed address is reported at its own field
+	for field, line := range map[int]string{
+		8: "r 1.5 2 3 tcp 1040 ------- 1 x.y 3.0 7 9",
+		9: "r 1.5 2 3 tcp 1040 ------- 1 0.0 3 7 9",
+	} {
+		_, err := parseTraceLine(line)
+		if perr, ok := err.(*ParseError); !ok || perr.Field != field {
+			t.Errorf("%q: err = %v, want a *ParseError at field %d", line, err, field)
+		}
+	}
+}
+
+func TestTraceWriterKeepsTimeText(t *testing.T) {
+	// Every line has all 12 fields, and the times are not how Go would format them
+	text := "+ 0.50 1 2 tcp 1040 ---A--- 1 0.0 3.0 7 9\n" +
+		"- 1.000000 1 2 tcp 1040 ------- 1 0.0 3.0 7 9\n" +
+		"r 1.5e0 1 2 tcp 1040 ------- 1 0.0 3.0 7 9\n" +
+		"d 2 1 2 tcp 1040 ------- 1 0.0 3.0 7 9\n"
+	var out bytes.Buffer
+	writer := NewTraceWriter(&out)
+	for _, trace := range parseTestTraces(t, text) {
+		if err := writer.Write(trace); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != text {
+		t.Errorf("wrote\n%s\nwant\n%s", out.String(), text)
+	}
+}
@@ -0,0 +1,118 @@
+package pkg
+
+import "math"
+
+// UtilizationMeter measures how busy a link is in a single pass over a
+// trace. Every '-' starts a transmission that lasts the packet's size over
+// the link bandwidth, and the link is idle whenever it is not transmitting.
+// Feed it every trace of the link, not just a single flow
+type UtilizationMeter struct {
+	link      Link
+	bandwidth float64 // In bits per second
+	span      TimeSpan
+
+	first_time float64 // Time of the first event on the link, or NaN before it
+	last_time  float64 // Time of the last event on the link or the end of the last transmission
+	busy_until float64 // End of the current transmission, or NaN before the first one
+
+	busy_time    float64 // Seconds spent transmitting inside the span
+	idle_periods []TimeSpan
+	bytes        int // Bytes received over the link from every flow inside the span
+}
+
+// Create a UtilizationMeter for the link 'from_node' -> 'to_node' of
+// 'bandwidth' bits per second that only measures inside 'span'
+func NewUtilizationMeter(from_node int, to_node int, bandwidth float64, span TimeSpan) *UtilizationMeter {
+	return &UtilizationMeter{
+		link:       Link{From: from_node, To: to_node},
+		bandwidth:  bandwidth,
+		span:       span,
+		first_time: math.NaN(),
+		busy_until: math.NaN(),
+	}
+}
+
+// Add the next trace. Traces must arrive in time order
+func (m *UtilizationMeter) Add(trace *Trace) {
+	if trace.From != m.link.From || trace.To != m.link.To {
+		return
+	}
+	if math.IsNaN(m.first_time) {
+		m.first_time = trace.Time
+	}
+	m.last_time = math.Max(m.last_time, trace.Time)
+
+	switch trace.Event {
+	case Dequeue:
+		start := trace.Time
+		end := start + float64(trace.Size)*8/m.bandwidth
+		if !math.IsNaN(m.busy_until) && start > m.busy_until {
+			m.addIdle(m.busy_until, start)
+		}
+		if !math.IsNaN(m.busy_until) && start < m.busy_until {
+			start = m.busy_until // The trace overlaps transmissions, so never count time twice
+		}
+		m.busy_time += m.clip(start, end)
+		m.busy_until = math.Max(end, start)
+		m.last_time = math.Max(m.last_time, m.busy_until)
+	case Receive:
+		if m.span.Contains(trace.Time) {
+			m.bytes += trace.Size
+		}
+	}
+}
+
+// Get the part of [start, end) that is inside the span in seconds
+func (m *UtilizationMeter) clip(start float64, end float64) float64 {
+	return math.Max(0, math.Min(end, m.span.End)-math.Max(start, m.span.Start))
+}
+
+// Record the idle period [start, end) clipped to the span
+func (m *UtilizationMeter) addIdle(start float64, end float64) {
+	start = math.Max(start, m.span.Start)
+	end = math.Min(end, m.span.End)
+	if end > start {
+		m.idle_periods = append(m.idle_periods, AbsoluteSpan(start, end))
+	}
+}
+
+// Get the length in seconds of the measured period, which is the span
+// clipped to the first and last activity on the link
+func (m *UtilizationMeter) Duration() float64 {
+	if math.IsNaN(m.first_time) {
+		return 0
+	}
+	return math.Max(0, math.Min(m.last_time, m.span.End)-math.Max(m.first_time, m.span.Start))
+}
+
+// Get the fraction of the measured period that the link spent transmitting, from 0 to 1
+func (m *UtilizationMeter) Utilization() float64 {
+	duration := m.Duration()
+	if duration == 0 {
+		return 0
+	}
+	return m.busy_time / duration
+}
+
+// Get the periods between two transmissions when the link was idle
+func (m *UtilizationMeter) IdlePeriods() []TimeSpan {
+	return m.idle_periods
+}
+
+// Get the total idle time in seconds
+func (m *UtilizationMeter) IdleTime() float64 {
+	var idle float64
+	for _, period := range m.idle_periods {
+		idle += period.Length()
+	}
+	return idle
+}
+
+// Get the aggregate throughput of every flow over the link in Mbps
+func (m *UtilizationMeter) Throughput() float64 {
+	duration := m.Duration()
+	if duration == 0 {
+		return 0
+	}
+	return toMbps(m.bytes, duration)
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"math"
+	"testing"
+)
+
+// Three 1000 byte packets on a 1 Mbps link 0->1, which takes 8ms to send
+// each. Pid 1 is dequeued before pid 0 is fully sent, so the overlap is only
+// counted once and the link is busy until 0.014, then idle until pid 2 at 0.05
+const utilizationTrace = `+ 0 0 1 tcp 1000 ------- 1 0.0 1.0 0 0
+- 0 0 1 tcp 1000 ------- 1 0.0 1.0 0 0
++ 0.002 0 1 tcp 1000 ------- 1 0.0 1.0 1 1
+- 0.006 0 1 tcp 1000 ------- 1 0.0 1.0 1 1
+r 0.01 0 1 tcp 1000 ------- 1 0.0 1.0 0 0
+r 0.018 0 1 tcp 1000 ------- 1 0.0 1.0 1 1
++ 0.02 1 2 tcp 1000 ------- 1 0.0 1.0 1 1
++ 0.05 0 1 tcp 1000 ------- 1 0.0 1.0 2 2
+- 0.05 0 1 tcp 1000 ------- 1 0.0 1.0 2 2
+r 0.06 0 1 tcp 1000 ------- 1 0.0 1.0 2 2
+`
+
+func TestUtilizationMeter(t *testing.T) {
+	tests := []struct {
+		name        string
+		span        TimeSpan
+		utilization float64
+		idle        []TimeSpan
+		throughput  float64
+	}{
+		// 14ms and 8ms busy over the 60ms from the first to the last event, and 3000 bytes received
+		{"full", FullSpan(), 0.022 / 0.06, []TimeSpan{{0.014, 0.05}}, 0.024 / 0.06},
+		// 4ms and 5ms busy over the 45ms span, and pid 0 and 1 received inside it
+		{"clipped", AbsoluteSpan(0.01, 0.055), 0.009 / 0.045, []TimeSpan{{0.014, 0.05}}, 0.016 / 0.045},
+		{"idle only", AbsoluteSpan(0.02, 0.04), 0, []TimeSpan{{0.02, 0.04}}, 0},
+	}
+	for _, test := range tests {
+		meter := NewUtilizationMeter(0, 1, 1e6, test.span)
+		for _, trace := range parseTestTraces(t, utilizationTrace) {
+			meter.Add(trace)
+		}
+		if got := meter.Utilization(); math.Abs(got-test.utilization) > 1e-9 {
+			t.Errorf("%s: utilization = %g, want %g", test.name, got, test.utilization)
+		}
+		idle := meter.IdlePeriods()
+		if len(idle) != len(test.idle) {
+			t.Errorf("%s: idle periods = %v, want %v", test.name, idle, test.idle)
+			continue
+		}
+		var idle_time float64
+		for i := range idle {
+			if math.Abs(idle[i].Start-test.idle[i].Start) > 1e-9 || math.Abs(idle[i].End-test.idle[i].End) > 1e-9 {
+				t.Errorf("%s: idle periods = %v, want %v", test.name, idle, test.idle)
+			}
+			idle_time += test.idle[i].Length()
+		}
+		if math.Abs(meter.IdleTime()-idle_time) > 1e-9 {
+			t.Errorf("%s: idle time = %g, want %g", test.name, meter.IdleTime(), idle_time)
+		}
+		if got := meter.Throughput(); math.Abs(got-test.throughput) > 1e-9 {
+			t.Errorf("%s: throughput = %g Mbps, want %g", test.name, got, test.throughput)
+		}
+	}
+}
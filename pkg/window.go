@@ -0,0 +1,155 @@
+package pkg
+
+import "math"
+
+// Window turns a stream of received packets into a throughput series. Times
+// are in seconds and throughputs in Mbps (10^6 bits per second)
+type Window interface {
+	// Add a packet of 'size' bytes received at 'time'. Packets must arrive in time order
+	Add(time float64, size int)
+	// Return slice times and slice throughputs
+	Result() ([]float64, []float64)
+}
+
+// Convert 'bytes' over 'seconds' to Mbps
+func toMbps(bytes int, seconds float64) float64 {
+	return float64(bytes) / seconds / 125000
+}
+
+// Check if a packet received at 'received' has left a sliding window of
+// 'size' seconds by 'time'. A packet leaves exactly 'size' seconds after it
+// arrived, so the window covers (time - size, time]
+func leftWindow(received float64, time float64, size float64) bool {
+	return time >= received+size
+}
+
+// SlidingWindow measures the bytes received in the last 'size' seconds. It
+// emits a tick every time a packet enters or leaves the window, so the
+// series is exact at every change. Only the packets inside the window are
+// kept in memory
+type SlidingWindow struct {
+	size float64
+
+	win_times []float64 // Receive times of the packets in the current window
+	win_sizes []int     // Sizes of the packets in the current window, aligned with win_times
+	win_bytes int       // The number of bytes in the current window
+
+	time_ticks       []float64
+	throughput_ticks []float64
+}
+
+// Create a SlidingWindow of 'size' seconds
+func NewSlidingWindow(size float64) *SlidingWindow {
+	return &SlidingWindow{size: size}
+}
+
+// Add a packet of 'size' bytes received at 'time'
+func (w *SlidingWindow) Add(time float64, size int) {
+	// Expire every packet that left the window by the time this one arrived
+	for len(w.win_times) > 0 && leftWindow(w.win_times[0], time, w.size) {
+		w.win_bytes -= w.win_sizes[0]
+		// A packet that leaves as this one enters shares its tick
+		if expiry := w.win_times[0] + w.size; expiry < time {
+			w.time_ticks = append(w.time_ticks, expiry)
+			w.throughput_ticks = append(w.throughput_ticks, toMbps(w.win_bytes, w.size))
+		}
+		w.win_times = w.win_times[1:]
+		w.win_sizes = w.win_sizes[1:]
+	}
+	w.win_times = append(w.win_times, time)
+	w.win_sizes = append(w.win_sizes, size)
+	w.win_bytes += size
+	w.time_ticks = append(w.time_ticks, time)
+	w.throughput_ticks = append(w.throughput_ticks, toMbps(w.win_bytes, w.size))
+}
+
+// Return slice times and slice throughputs
+func (w *SlidingWindow) Result() ([]float64, []float64) {
+	return w.time_ticks, w.throughput_ticks
+}
+
+// TumblingWindow counts the bytes received in fixed, non-overlapping bins of
+// 'size' seconds starting at 'origin'. Each bin emits one tick at its start
+// time, and bins without packets emit zero. The series ends with the last bin
+// that received a packet, which is measured over its full size
+type TumblingWindow struct {
+	size   float64
+	origin float64
+
+	bin       int // The index of the current bin
+	bin_bytes int // The number of bytes in the current bin
+	started   bool
+
+	time_ticks       []float64
+	throughput_ticks []float64
+}
+
+// Create a TumblingWindow of 'size' seconds with bins starting at 'origin'
+func NewTumblingWindow(size float64, origin float64) *TumblingWindow {
+	return &TumblingWindow{size: size, origin: origin}
+}
+
+// Add a packet of 'size' bytes received at 'time'
+func (w *TumblingWindow) Add(time float64, size int) {
+	bin := int(math.Floor((time - w.origin) / w.size))
+	if !w.started {
+		w.bin = bin
+		w.started = true
+	}
+	for w.bin < bin {
+		w.flush()
+		w.bin++
+	}
+	w.bin_bytes += size
+}
+
+// Emit the current bin and start the next one
+func (w *TumblingWindow) flush() {
+	w.time_ticks = append(w.time_ticks, w.origin+float64(w.bin)*w.size)
+	w.throughput_ticks = append(w.throughput_ticks, toMbps(w.bin_bytes, w.size))
+	w.bin_bytes = 0
+}
+
+// Return slice times and slice throughputs, including the last bin
+func (w *TumblingWindow) Result() ([]float64, []float64) {
+	if !w.started {
+		return nil, nil
+	}
+	times := append([]float64(nil), w.time_ticks...)
+	throughputs := append([]float64(nil), w.throughput_ticks...)
+	times = append(times, w.origin+float64(w.bin)*w.size)
+	throughputs = append(throughputs, toMbps(w.bin_bytes, w.size))
+	return times, throughputs
+}
+
+// EWMAWindow smooths the bins of a TumblingWindow with an exponentially
+// weighted moving average, where each bin contributes 'alpha' (0 to 1) of
+// its throughput and the previous average contributes the rest
+type EWMAWindow struct {
+	bins  *TumblingWindow
+	alpha float64
+}
+
+// Create an EWMAWindow over bins of 'size' seconds starting at 'origin'
+func NewEWMAWindow(size float64, origin float64, alpha float64) *EWMAWindow {
+	return &EWMAWindow{bins: NewTumblingWindow(size, origin), alpha: alpha}
+}
+
+// Add a packet of 'size' bytes received at 'time'
+func (w *EWMAWindow) Add(time float64, size int) {
+	w.bins.Add(time, size)
+}
+
+// Return slice times and slice smoothed throughputs. The first bin seeds the average
+func (w *EWMAWindow) Result() ([]float64, []float64) {
+	times, throughputs := w.bins.Result()
+	smoothed := make([]float64, len(throughputs))
+	for i, throughput := range throughputs {
+		if i == 0 {
+			smoothed[i] = throughput
+		} else {
+			smoothed[i] = w.alpha*throughput + (1-w.alpha)*smoothed[i-1]
+		}
+	}
+	return times, smoothed
+}
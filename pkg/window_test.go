@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"math"
+	"testing"
+)
+
+// 125000 bytes is 1 Mb, so a packet per second of this size is 1 Mbps
+const megabit = 125000
+
+func checkSeries(t *testing.T, name string, times []float64, values []float64, want_times []float64, want_values []float64) {
+	t.Helper()
+	if len(times) != len(want_times) || len(values) != len(want_values) {
+		t.Fatalf("%s: got times %v and Mbps %v, want %v and %v", name, times, values, want_times, want_values)
+	}
+	for i := range want_times {
+		if math.Abs(times[i]-want_times[i]) > 1e-9 || math.Abs(values[i]-want_values[i]) > 1e-9 {
+			t.Fatalf("%s: got times %v and Mbps %v, want %v and %v", name, times, values, want_times, want_values)
+		}
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	w := NewSlidingWindow(1)
+	w.Add(0, megabit)
+	w.Add(0.5, megabit)
+	w.Add(2, 2*megabit) // Both earlier packets leave the window first, each with its own tick
+	times, mbps := w.Result()
+	checkSeries(t, "sliding", times, mbps, []float64{0, 0.5, 1, 1.5, 2}, []float64{1, 2, 1, 0, 2})
+}
+
+func TestSlidingWindowEdge(t *testing.T) {
+	// A packet that arrives exactly one window after another replaces it
+	w := NewSlidingWindow(1)
+	w.Add(0, megabit)
+	w.Add(1, megabit)
+	times, mbps := w.Result()
+	checkSeries(t, "sliding edge", times, mbps, []float64{0, 1}, []float64{1, 1})
+}
+
+func TestTumblingWindow(t *testing.T) {
+	w := NewTumblingWindow(1, 0)
+	w.Add(0.2, megabit)
+	w.Add(0.7, megabit)
+	w.Add(2.5, megabit) // Bin 1 is empty
+	times, mbps := w.Result()
+	checkSeries(t, "tumbling", times, mbps, []float64{0, 1, 2}, []float64{2, 0, 1})
+}
+
+func TestTumblingWindowBeforeOrigin(t *testing.T) {
+	// t=-1 is exactly on a bin boundary, so it starts bin -1 rather than ending bin -2
+	w := NewTumblingWindow(1, 0)
+	w.Add(-1, megabit)
+	w.Add(0.5, megabit)
+	times, mbps := w.Result()
+	checkSeries(t, "tumbling before origin", times, mbps, []float64{-1, 0}, []float64{1, 1})
+
+	w = NewTumblingWindow(1, 0)
+	w.Add(-1.5, megabit)
+	w.Add(-0.25, megabit)
+	times, mbps = w.Result()
+	checkSeries(t, "tumbling inside negative bins", times, mbps, []float64{-2, -1}, []float64{1, 1})
+}
+
+func TestEWMAWindow(t *testing.T) {
+	w := NewEWMAWindow(1, 0, 0.5)
+	w.Add(0.2, megabit)
+	w.Add(0.7, megabit)
+	w.Add(2.5, megabit)
+	times, mbps := w.Result()
+	// Bins of 2, 0 and 1 Mbps: 2, then 0.5*0 + 0.5*2, then 0.5*1 + 0.5*1
+	checkSeries(t, "ewma", times, mbps, []float64{0, 1, 2}, []float64{2, 1, 1})
+}
+
+func TestThroughputMeterAverage(t *testing.T) {
+	traces := []*Trace{
+		NewTrace(Enqueue, 0.4, 1, 2, TCP, megabit, 1, 0, 0), // Not a receive
+		NewTrace(Receive, 0.5, 1, 2, TCP, megabit, 1, 0, 0),
+		NewTrace(Receive, 0.6, 2, 3, TCP, megabit, 1, 0, 0), // Another link
+		NewTrace(Receive, 1.0, 1, 2, TCP, megabit, 1, 1, 1),
+		NewTrace(Receive, 1.5, 1, 2, TCP, megabit, 1, 2, 2),
+		NewTrace(Receive, 2.5, 1, 2, TCP, megabit, 1, 3, 3),
+	}
+	tests := []struct {
+		name string
+		span TimeSpan
+		want float64
+	}{
+		// 3 Mb received inside [0, 2) over its 2 seconds
+		{"bounded", AbsoluteSpan(0, 2), 1.5},
+		// Receives at 1, 1.5 and 2.5: the 2 Mb after the first one over 1.5 seconds
+		{"warmup", WarmupSpan(0, 1), 2 / 1.5},
+		// Receives from 0.5 to 2.5: the 3 Mb after the first one over 2 seconds
+		{"full", FullSpan(), 1.5},
+	}
+	for _, test := range tests {
+		_, _, got := CalculateThroughput(traces, 1, 2, test.span, 0.2)
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: average = %v Mbps, want %v", test.name, got, test.want)
+		}
+	}
+}
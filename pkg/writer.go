@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// TraceWriter writes traces in the canonical ns2 trace format, one line per
+// trace. Times keep the formatting they had when they were parsed, so a
+// parsed trace is written back byte for byte
+type TraceWriter struct {
+	writer *bufio.Writer
+	closer io.Closer
+	buf    []byte
+}
+
+// Create a TraceWriter that writes ns2 trace lines to w
+func NewTraceWriter(w io.Writer) *TraceWriter {
+	return &TraceWriter{writer: bufio.NewWriter(w)}
+}
+
+// Create a trace file for writing. The caller must Close the writer when done
+func CreateTraceFile(file string) (*TraceWriter, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	writer := NewTraceWriter(f)
+	writer.closer = f
+	return writer, nil
+}
+
+// Write a single trace as one line
+func (w *TraceWriter) Write(trace *Trace) error {
+	w.buf = trace.appendText(w.buf[:0])
+	w.buf = append(w.buf, '\n')
+	_, err := w.writer.Write(w.buf)
+	return err
+}
+
+// Flush any buffered lines to the underlying writer
+func (w *TraceWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+// Flush any buffered lines and close the underlying file if the writer was
+// created with CreateTraceFile
+func (w *TraceWriter) Close() error {
+	err := w.Flush()
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Write a slice of traces to a new trace file
+func WriteTraceFile(file string, traces []*Trace) error {
+	writer, err := CreateTraceFile(file)
+	if err != nil {
+		return err
+	}
+	for _, trace := range traces {
+		if err := writer.Write(trace); err != nil {
+			writer.Close()
+			return err
+		}
+	}
+	return writer.Close()
+}
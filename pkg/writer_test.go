@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Lines with non-canonical times, set flags and multi-digit node.port addresses
+const roundTripTrace = `+ 0.50 0 1 tcp 40 ------- 1 0.0 3.0 0 0
+- 0.5 0 1 tcp 40 ------- 1 0.0 3.0 0 0
+r 0.510032 0 1 tcp 40 ------- 1 0.0 3.0 0 0
++ 1.000000 1 2 cbr 1000 ------N 2 1.0 2.1 7 12
+d 1.2300 1 2 tcp 1040 C-----N 1 0.0 3.0 14 31
+r 2 2 3 ack 40 CP-AEFN 1 3.0 0.0 14 32
++ 12.5e-1 10 11 tcp 1040 ---A--- 23 10.17 11.255 1234567 89012345
+`
+
+func TestWriteTraceFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.tr")
+	out := filepath.Join(dir, "out.tr")
+	if err := os.WriteFile(in, []byte(roundTripTrace), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	traces, err := ParseTraceFile(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteTraceFile(out, traces); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte(roundTripTrace)) {
+		t.Errorf("round trip changed the trace\ngot:\n%s\nwant:\n%s", got, roundTripTrace)
+	}
+}
+
+func TestWriteChangedTimeIsCanonical(t *testing.T) {
+	trace, err := parseTraceLine("+ 0.50 0 1 tcp 40 C-----N 1 0.0 3.0 0 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trace.Flags.Has(FlagECNEcho | FlagECT) {
+		t.Errorf("flags = %v, want C-----N", trace.Flags)
+	}
+	trace.Time = 0.75
+	want := "+ 0.75 0 1 tcp 40 C-----N 1 0.0 3.0 0 0"
+	if got := trace.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}